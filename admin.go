@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// adminToken guards the /admin API, the API is off when it's empty.
+var adminToken string
+
+// lastUserMatch holds the *UserMatch of the latest user sync.
+var lastUserMatch atomic.Value
+
+// AdminUser is everything the bot knows about a user and why they might not be getting notifications.
+type AdminUser struct {
+	GitlabUsername string      `json:"gitlab_username,omitempty"`
+	GitlabID       int         `json:"gitlab_id,omitempty"`
+	SlackUsername  string      `json:"slack_username,omitempty"`
+	SlackID        string      `json:"slack_id,omitempty"`
+	Email          string      `json:"email,omitempty"`
+	Active         bool        `json:"active"`
+	Preferences    *UserConfig `json:"preferences,omitempty"`
+	// Reason says why a user isn't matched or won't get notifications, it's empty for those who will.
+	Reason string `json:"reason,omitempty"`
+}
+
+type adminUsersResponse struct {
+	LastSync        *time.Time  `json:"last_sync"`
+	Users           []AdminUser `json:"users"`
+	UnmatchedGitlab []AdminUser `json:"unmatched_gitlab"`
+	UnmatchedSlack  []AdminUser `json:"unmatched_slack"`
+	// ActiveUsernames are the ACTIVE_USERS, the only users notifications are sent to.
+	ActiveUsernames []string `json:"active_usernames"`
+}
+
+type AdminHandler struct {
+	handler http.Handler
+}
+
+// AdminHandler lets requests through with an "Authorization: Bearer <ADMIN_TOKEN>" header.
+func (h AdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if adminToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+// AdminUsersHandler lists matched users, the accounts that couldn't be matched and the active users.
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	response := adminUsersResponse{
+		Users:           []AdminUser{},
+		UnmatchedGitlab: []AdminUser{},
+		UnmatchedSlack:  []AdminUser{},
+		ActiveUsernames: []string{},
+	}
+
+	if users != nil {
+		for _, user := range *users {
+			response.Users = append(response.Users, adminUser(user))
+		}
+	}
+	if match, ok := lastUserMatch.Load().(*UserMatch); ok {
+		response.LastSync = &match.Time
+		for _, user := range match.UnmatchedGitlab {
+			response.UnmatchedGitlab = append(response.UnmatchedGitlab, unmatchedGitlabUser(user))
+		}
+		for _, user := range match.UnmatchedSlack {
+			response.UnmatchedSlack = append(response.UnmatchedSlack, unmatchedSlackUser(user))
+		}
+	}
+	if activeUsers != nil {
+		for _, user := range *activeUsers {
+			response.ActiveUsernames = append(response.ActiveUsernames, user.GitlabUsername)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// AdminUserHandler explains whether a single Gitlab user gets notifications.
+func AdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	if user := findUserByUsername(username); user != nil {
+		writeJSON(w, http.StatusOK, adminUser(*user))
+		return
+	}
+
+	if match, ok := lastUserMatch.Load().(*UserMatch); ok {
+		for _, user := range match.UnmatchedGitlab {
+			if user.GitlabUsername == username {
+				writeJSON(w, http.StatusOK, unmatchedGitlabUser(user))
+				return
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, AdminUser{
+		GitlabUsername: username,
+		Active:         activeUser(&User{GitlabUsername: username}),
+		Reason:         "not an active Gitlab user, or users haven't been synced yet",
+	})
+}
+
+// AdminSyncHandler matches Gitlab and Slack users right away instead of waiting for the next scheduled sync.
+func AdminSyncHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Populating users for an admin...")
+	match, err := refreshUsers()
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"last_sync":        match.Time,
+		"matched":          len(match.Matched),
+		"unmatched_gitlab": len(match.UnmatchedGitlab),
+		"unmatched_slack":  len(match.UnmatchedSlack),
+	})
+}
+
+func adminUser(user User) AdminUser {
+	admin := AdminUser{
+		GitlabUsername: user.GitlabUsername,
+		GitlabID:       user.GitlabID,
+		SlackUsername:  user.SlackUsername,
+		SlackID:        user.SlackID,
+		Email:          user.Email,
+		Active:         activeUser(&user),
+	}
+	if preferences, found := config.Users[user.GitlabUsername]; found {
+		admin.Preferences = &preferences
+	}
+	if !admin.Active {
+		admin.Reason = "not in ACTIVE_USERS"
+	}
+	return admin
+}
+
+func unmatchedGitlabUser(user User) AdminUser {
+	admin := AdminUser{
+		GitlabUsername: user.GitlabUsername,
+		GitlabID:       user.GitlabID,
+		Email:          user.Email,
+		Active:         activeUser(&user),
+		Reason:         fmt.Sprintf("no Slack account with the email %s", user.Email),
+	}
+	if user.Email == "" {
+		admin.Reason = "Gitlab doesn't show their email, the GITLAB_TOKEN needs to belong to an admin"
+	}
+	return admin
+}
+
+func unmatchedSlackUser(user User) AdminUser {
+	admin := AdminUser{
+		SlackUsername: user.SlackUsername,
+		SlackID:       user.SlackID,
+		Email:         user.Email,
+		Reason:        fmt.Sprintf("no Gitlab account with the email %s", user.Email),
+	}
+	if user.Email == "" {
+		admin.Reason = "Slack doesn't show their email, it's a bot or the SLACK_TOKEN lacks the users:read.email scope"
+	}
+	return admin
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAPIRequiresTheAdminToken(t *testing.T) {
+	defer func(previous string) { adminToken = previous }(adminToken)
+
+	tests := []struct {
+		adminToken    string
+		authorization string
+		status        int
+	}{
+		{"", "Bearer ", http.StatusNotFound},
+		{"admin-secret", "", http.StatusUnauthorized},
+		{"admin-secret", "Bearer wrong", http.StatusUnauthorized},
+		{"admin-secret", "Bearer admin-secret", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		adminToken = test.adminToken
+		rr := serveAdmin(t, "GET", "/admin/users", test.authorization)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("token %q: handler returned wrong status code: got %v want %v",
+				test.authorization, status, test.status)
+		}
+	}
+}
+
+func TestAdminUsersHandler(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	defer func(previous *Config) { config = previous }(config)
+	activeUsers = &[]User{{GitlabUsername: "smeriwether1"}}
+	notify := false
+	config = &Config{Users: map[string]UserConfig{"smeriwether1": {DigestTime: "off", NotifyOnDraft: &notify}}}
+	lastUserMatch.Store(&UserMatch{
+		Matched:         *users,
+		UnmatchedGitlab: []User{{GitlabUsername: "nobody", Email: "nobody@molecule.io"}},
+		UnmatchedSlack:  []User{{SlackUsername: "slackbot"}},
+	})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(AdminUsersHandler).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/users", nil))
+
+	var response adminUsersResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Users) != 2 {
+		t.Fatalf("handler returned wrong users: got %+v", response.Users)
+	}
+	if user := response.Users[0]; !user.Active || user.Preferences == nil || user.Preferences.DigestTime != "off" {
+		t.Errorf("handler returned wrong user: got %+v", user)
+	}
+	if user := response.Users[1]; user.Active || user.Reason != "not in ACTIVE_USERS" {
+		t.Errorf("handler returned wrong user: got %+v", user)
+	}
+	if len(response.UnmatchedGitlab) != 1 || response.UnmatchedGitlab[0].Reason != "no Slack account with the email nobody@molecule.io" {
+		t.Errorf("handler returned wrong unmatched Gitlab users: got %+v", response.UnmatchedGitlab)
+	}
+	if len(response.UnmatchedSlack) != 1 || response.UnmatchedSlack[0].SlackUsername != "slackbot" {
+		t.Errorf("handler returned wrong unmatched Slack users: got %+v", response.UnmatchedSlack)
+	}
+	if len(response.ActiveUsernames) != 1 || response.ActiveUsernames[0] != "smeriwether1" {
+		t.Errorf("handler returned wrong active users: got %v", response.ActiveUsernames)
+	}
+}
+
+func TestAdminUserHandler(t *testing.T) {
+	defer func(previous string) { adminToken = previous }(adminToken)
+	adminToken = "admin-secret"
+	lastUserMatch.Store(&UserMatch{UnmatchedGitlab: []User{{GitlabUsername: "nobody"}}})
+
+	rr := serveAdmin(t, "GET", "/admin/users/smeriwether2", "Bearer admin-secret")
+	var user AdminUser
+	if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || user.SlackID != "SLACKID2" {
+		t.Errorf("handler returned wrong user: got %d %+v", rr.Code, user)
+	}
+
+	rr = serveAdmin(t, "GET", "/admin/users/nobody", "Bearer admin-secret")
+	user = AdminUser{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || user.Reason == "" {
+		t.Errorf("handler returned wrong user: got %d %+v", rr.Code, user)
+	}
+
+	rr = serveAdmin(t, "GET", "/admin/users/someone-else", "Bearer admin-secret")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminSyncHandler(t *testing.T) {
+	defer func(previous string) { adminToken = previous }(adminToken)
+	defer func(previous *[]User) { users = previous }(users)
+	defer func(previous SlackReadWriter) { slackClient = previous }(slackClient)
+	defer func(previous GitlabReadWriter) { gitlabClient = previous }(gitlabClient)
+	adminToken = "admin-secret"
+	slackClient = &slackDirectoryStub{}
+	gitlabClient = &gitlabClientStub{users: []User{
+		{Email: "stephen1@molecule.io", GitlabID: 1, GitlabUsername: "smeriwether1"},
+	}}
+
+	rr := serveAdmin(t, "POST", "/admin/sync", "Bearer admin-secret")
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response["matched"] != 1.0 || response["unmatched_slack"] != 1.0 {
+		t.Errorf("handler returned wrong counts: got %v", response)
+	}
+	if users == nil || len(*users) != 1 {
+		t.Errorf("users were not replaced: got %v", users)
+	}
+}
+
+func serveAdmin(t *testing.T, method, path, authorization string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rr := httptest.NewRecorder()
+
+	newHandler().ServeHTTP(rr, req)
+	return rr
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCaptureMaxMB = 10
+	// captureBackups is how many rotated capture files are kept next to the current one.
+	captureBackups = 3
+	redacted       = "[REDACTED]"
+)
+
+// deliveryRecorder is set when CAPTURE_PATH is, every authenticated Gitlab request is recorded to it.
+var deliveryRecorder *DeliveryRecorder
+
+// captureMaxBytes reads CAPTURE_MAX_MB, how large a capture file grows before it's rotated.
+func captureMaxBytes(value string) (int64, error) {
+	if value == "" {
+		return defaultCaptureMaxMB * 1024 * 1024, nil
+	}
+	mb, err := strconv.Atoi(value)
+	if err != nil || mb <= 0 {
+		return 0, errors.New("CAPTURE_MAX_MB must be a positive number")
+	}
+	return int64(mb) * 1024 * 1024, nil
+}
+
+// redactedHeaders hold secrets and never make it into a capture file.
+var redactedHeaders = []string{"X-Gitlab-Token", "Authorization"}
+
+// DeliveryRecorder appends deliveries to a JSON lines file in the format replay reads.
+// Once the file reaches maxBytes it is moved to path.1, path.1 to path.2 and so on.
+type DeliveryRecorder struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+func NewDeliveryRecorder(path string, maxBytes int64) (*DeliveryRecorder, error) {
+	recorder := &DeliveryRecorder{path: path, maxBytes: maxBytes}
+	if err := recorder.open(); err != nil {
+		return nil, err
+	}
+	return recorder, nil
+}
+
+func (recorder *DeliveryRecorder) open() error {
+	file, err := os.OpenFile(recorder.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	recorder.file = file
+	recorder.size = info.Size()
+	return nil
+}
+
+// Record writes the delivery as a single line, rotating the file first if the line doesn't fit.
+func (recorder *DeliveryRecorder) Record(delivery Delivery) error {
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	if recorder.size > 0 && recorder.size+int64(len(line)) > recorder.maxBytes {
+		if err := recorder.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := recorder.file.Write(line)
+	recorder.size += int64(n)
+	return err
+}
+
+func (recorder *DeliveryRecorder) rotate() error {
+	if err := recorder.file.Close(); err != nil {
+		return err
+	}
+	for i := captureBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", recorder.path, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", recorder.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(recorder.path, recorder.path+".1"); err != nil {
+		return err
+	}
+	return recorder.open()
+}
+
+func (recorder *DeliveryRecorder) Close() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return recorder.file.Close()
+}
+
+type CaptureHandler struct {
+	handler  http.Handler
+	recorder *DeliveryRecorder
+}
+
+// CaptureHandler records Gitlab deliveries before handing them on, the handlers never know the difference.
+func (h CaptureHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Only Gitlab deliveries are worth replaying, admin and Slack requests never reach the webhook handlers
+	if req.URL == nil || req.Method != "POST" || !webhookRoute(req) {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = replayBody(body, err)
+	if err != nil {
+		log.Println("Error capturing request:", err)
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	if err := h.recorder.Record(newDelivery(req, body)); err != nil {
+		log.Println("Error capturing request:", err)
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+func newDelivery(req *http.Request, body []byte) Delivery {
+	headers := map[string]string{}
+	for name, values := range req.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+	for _, name := range redactedHeaders {
+		if _, found := headers[name]; found {
+			headers[name] = redacted
+		}
+	}
+
+	delivery := Delivery{
+		Time:    time.Now().UTC(),
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Headers: headers,
+		Body:    json.RawMessage(body),
+	}
+	// Bodies that aren't JSON are kept as a string so the line stays valid
+	if !json.Valid(body) {
+		delivery.Body, _ = json.Marshal(string(body))
+	}
+	return delivery
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureHandlerRecordsDeliveriesForReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder, err := NewDeliveryRecorder(filepath.Join(dir, "deliveries.jsonl"), 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	var received string
+	handler := CaptureHandler{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+	}), recorder}
+
+	for _, body := range []string{`{"object_kind": "push"}`, "not json"} {
+		req, err := http.NewRequest("POST", "/webhook", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Gitlab-Event", "Push Hook")
+		req.Header.Set("X-Gitlab-Token", "secret")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if received != body {
+			t.Errorf("handler received wrong body: got %q want %q", received, body)
+		}
+	}
+
+	// Admin and Slack requests aren't Gitlab's, and admin ones get this far without a token
+	for _, path := range []string{"/admin/sync", "/slack/actions"} {
+		req, err := http.NewRequest("POST", path, strings.NewReader(`{"object_kind": "push"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "deliveries.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	deliveries, err := readDeliveries(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("recorded wrong number of deliveries: got %d want 2", len(deliveries))
+	}
+
+	if token := deliveries[0].Headers["X-Gitlab-Token"]; token != redacted {
+		t.Errorf("token was not redacted: got %q", token)
+	}
+	if event := deliveries[0].Headers["X-Gitlab-Event"]; event != "Push Hook" {
+		t.Errorf("recorded wrong event header: got %q want %q", event, "Push Hook")
+	}
+	// JSON bodies are kept as JSON, which loses the whitespace
+	if payload := string(deliveries[0].Payload()); payload != `{"object_kind":"push"}` {
+		t.Errorf("recorded wrong body: got %q want %q", payload, `{"object_kind":"push"}`)
+	}
+	if payload := string(deliveries[1].Payload()); payload != "not json" {
+		t.Errorf("recorded wrong body: got %q want %q", payload, "not json")
+	}
+}
+
+func TestDeliveryRecorderRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "deliveries.jsonl")
+	recorder, err := NewDeliveryRecorder(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	for i := 0; i < 6; i++ {
+		if err := recorder.Record(Delivery{Headers: map[string]string{"X-Gitlab-Event": "Push Hook"}, Body: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2", path + ".3"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("expected %s to exist: %v", filepath.Base(name), err)
+		}
+	}
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("expected only %d backups to be kept", captureBackups)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errUsage is returned by commands called with the wrong arguments, their usage is printed for them.
+var errUsage = errors.New("wrong arguments")
+
+// sendTestTimeout is how long send-test waits for its message to be sent.
+const sendTestTimeout = 30 * time.Second
+
+// testCommentAuthor writes send-test's comment, the ID can't belong to a Gitlab user.
+var testCommentAuthor = User{GitlabID: -1, GitlabUsername: "gitlab-bot"}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(flags *flag.FlagSet, args []string, out io.Writer) error
+}
+
+var commands = []command{
+	{"serve", "", "Listen for Gitlab events, this is what happens without a command", serve},
+	{"sync-users", "[-o users.json]", "Match Gitlab and Slack users once and report who was matched", syncUsers},
+	{"send-test", "<gitlab-username>", "Send a test DM to a Gitlab user through the whole pipeline", sendTest},
+	{"validate-config", "[-config config.json]", "Check the config file and settings without starting anything", validateConfig},
+	{"replay", "[flags] deliveries.jsonl", "Run recorded deliveries through the handlers and print the messages instead of sending them", replay},
+}
+
+// runCommand runs the subcommand named by the first argument and returns the exit code.
+// It's 0 when all went well, 1 when the command failed and 2 when it was called wrong.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	} else if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		name, args = "help", args[1:]
+	}
+
+	if name == "help" {
+		if len(args) == 0 {
+			printUsage(stdout)
+			return 0
+		}
+		name, args = args[0], []string{"-h"}
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "gitlab-bot: unknown command %q\n\n", name)
+		printUsage(stderr)
+		return 2
+	}
+
+	// -h prints to stdout since it's what was asked for
+	usageOut := stderr
+	for _, arg := range args {
+		if arg == "-h" || arg == "-help" || arg == "--help" {
+			usageOut = stdout
+		}
+	}
+
+	// The flag package prints the usage whenever it can't parse the flags
+	usageShown := false
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(usageOut)
+	flags.Usage = func() {
+		usageShown = true
+		fmt.Fprintf(usageOut, "usage: %s\n\n%s\n", strings.TrimSpace("gitlab-bot "+cmd.name+" "+cmd.args), cmd.summary)
+		flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(usageOut, "  -%s\t%s\n", f.Name, f.Usage)
+		})
+	}
+
+	err := cmd.run(flags, args, stdout)
+	switch {
+	case err == nil:
+		return 0
+	case err == flag.ErrHelp:
+		return 0
+	case err == errUsage:
+		usageOut = stderr
+		flags.Usage()
+		return 2
+	case usageShown:
+		// The flag package already said what was wrong
+		return 2
+	}
+
+	fmt.Fprintf(stderr, "gitlab-bot %s: %v\n", cmd.name, err)
+	return 1
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintln(out, "usage: gitlab-bot [command] [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, `Run "gitlab-bot help <command>" for a command's flags.`)
+	fmt.Fprintln(out, "Settings like GITLAB_TOKEN and SLACK_TOKEN are read from the environment.")
+}
+
+// syncUsers matches users like the server does every few hours, the users file can be used with replay.
+func syncUsers(flags *flag.FlagSet, args []string, out io.Writer) error {
+	output := flags.String("o", "", "write the matched users to this JSON file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	if err := setup(); err != nil {
+		return err
+	}
+
+	match, err := matchUsers()
+	if err != nil {
+		return err
+	}
+	matched, unmatched := match.Matched, match.UnmatchedGitlab
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].GitlabUsername < matched[j].GitlabUsername })
+	fmt.Fprintf(out, "Matched %d users:\n", len(matched))
+	for _, user := range matched {
+		fmt.Fprintf(out, "  %s -> @%s (%s)\n", user.GitlabUsername, user.SlackUsername, user.Email)
+	}
+
+	sort.Slice(unmatched, func(i, j int) bool { return unmatched[i].GitlabUsername < unmatched[j].GitlabUsername })
+	fmt.Fprintf(out, "%d Gitlab users without a Slack account with the same email:\n", len(unmatched))
+	for _, user := range unmatched {
+		fmt.Fprintf(out, "  %s (%s)\n", user.GitlabUsername, user.Email)
+	}
+
+	if *output != "" {
+		data, err := json.MarshalIndent(matched, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*output, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote %s\n", *output)
+	}
+	return nil
+}
+
+// sendTest runs a made up comment on one of the user's merge requests through the handlers.
+// It succeeds once Slack took the message, so it checks the tokens, user matching and the handlers in one go.
+func sendTest(flags *flag.FlagSet, args []string, out io.Writer) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	username := flags.Arg(0)
+
+	if err := setup(); err != nil {
+		return err
+	}
+	match, err := matchUsers()
+	if err != nil {
+		return err
+	}
+	matched := match.Matched
+	users = &matched
+
+	user := findUserByUsername(username)
+	if user == nil {
+		return fmt.Errorf("%s has no Slack account with the same email, see sync-users", username)
+	}
+	// The comment is written by the bot itself, it needs to be a user to show up in the message
+	matched = append(matched, testCommentAuthor)
+	users = &matched
+
+	// The test goes out whatever the user's settings are
+	activeUsers = &[]User{*user}
+	config.CommentBatchSeconds = 0
+	config.CommentFilters = CommentFilters{}
+
+	sent := &sentSlackClient{SlackReadWriter: slackClient, sent: make(chan string, 1)}
+	slackClient = sent
+
+	event := testCommentEvent(user)
+	req, err := http.NewRequest("POST", "/webhook", strings.NewReader(event))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gitlab-Event", "Note Hook")
+	req.Header.Set("X-Gitlab-Token", tokenFor([]byte(event)))
+
+	rr := httptest.NewRecorder()
+	newHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		return fmt.Errorf("webhook returned %d %s", rr.Code, strings.TrimSpace(rr.Body.String()))
+	}
+
+	select {
+	case channel := <-sent.sent:
+		fmt.Fprintf(out, "Sent a test message to %s (%s)\n", user.GitlabUsername, channel)
+		return nil
+	case <-time.After(sendTestTimeout):
+		return errors.New("timed out waiting for the message to be sent, check the log")
+	}
+}
+
+func testCommentEvent(user *User) string {
+	event := map[string]interface{}{
+		"object_kind": "note",
+		"user":        map[string]interface{}{"id": testCommentAuthor.GitlabID, "username": testCommentAuthor.GitlabUsername},
+		"object_attributes": map[string]interface{}{
+			"id":            0,
+			"note":          "This is a test message from gitlab-bot, everything is working.",
+			"noteable_type": "MergeRequest",
+			"author_id":     testCommentAuthor.GitlabID,
+			"url":           "https://gitlab.com",
+		},
+		"merge_request": map[string]interface{}{"iid": 1, "title": "Test", "author_id": user.GitlabID},
+	}
+	data, _ := json.Marshal(event)
+	return string(data)
+}
+
+// sentSlackClient tells send-test which channel its message went to.
+type sentSlackClient struct {
+	SlackReadWriter
+	sent chan string
+}
+
+func (client *sentSlackClient) PostInteractiveMessage(channel, message, attachment string, actions []MessageAction) (string, string) {
+	postedChannel, timestamp := client.SlackReadWriter.PostInteractiveMessage(channel, message, attachment, actions)
+	if postedChannel != "" {
+		select {
+		case client.sent <- postedChannel:
+		default:
+		}
+	}
+	return postedChannel, timestamp
+}
+
+func validateConfig(flags *flag.FlagSet, args []string, out io.Writer) error {
+	path := flags.String("config", os.Getenv("CONFIG_PATH"), "config file, defaults to CONFIG_PATH")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	// Every field of the config file is optional, so going without one is fine
+	cfg, err := loadConfig(*path)
+	problems := validateEnvironment()
+	if err != nil {
+		problems = append([]error{err}, problems...)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(out, problem)
+		}
+		if len(problems) == 1 {
+			return errors.New("found 1 problem")
+		}
+		return fmt.Errorf("found %d problems", len(problems))
+	}
+
+	name := *path
+	if name == "" {
+		name = "The default config"
+	}
+	fmt.Fprintf(out, "%s is valid: %d projects, %d users, %d deployment routes\n",
+		name, len(cfg.Projects), len(cfg.Users), len(cfg.DeploymentRoutes))
+	return nil
+}
+
+// validateEnvironment checks the settings serve reads from the environment and returns everything wrong with them.
+func validateEnvironment() []error {
+	var problems []error
+	for _, name := range []string{"SLACK_TOKEN", "GITLAB_TOKEN"} {
+		if os.Getenv(name) == "" {
+			problems = append(problems, fmt.Errorf("%s must not be empty", name))
+		}
+	}
+	if _, err := parseActiveUsers(os.Getenv("ACTIVE_USERS")); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := captureMaxBytes(os.Getenv("CAPTURE_MAX_MB")); err != nil {
+		problems = append(problems, err)
+	}
+
+	allowedCIDRs, trustedProxies := os.Getenv("WEBHOOK_ALLOWED_CIDRS"), os.Getenv("TRUSTED_PROXIES")
+	if allowedCIDRs != "" {
+		if _, err := NewSourceAllowlist(allowedCIDRs, trustedProxies); err != nil {
+			problems = append(problems, err)
+		}
+	} else if trustedProxies != "" {
+		problems = append(problems, errors.New("TRUSTED_PROXIES is only used with WEBHOOK_ALLOWED_CIDRS"))
+	}
+
+	if _, err := tlsSettingsFromEnv().Config(); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
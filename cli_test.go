@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandExitCodes(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"help"}, 0, "validate-config", ""},
+		{[]string{"--help"}, 0, "send-test", ""},
+		{[]string{"help", "replay"}, 0, "usage: gitlab-bot replay [flags] deliveries.jsonl", ""},
+		{[]string{"sync-users", "-h"}, 0, "-o", ""},
+		{[]string{"deploy"}, 2, "", `unknown command "deploy"`},
+		{[]string{"send-test"}, 2, "", "usage: gitlab-bot send-test <gitlab-username>"},
+		{[]string{"validate-config", "-bogus"}, 2, "", "flag provided but not defined: -bogus"},
+		{[]string{"validate-config", "-config", "/does/not/exist.json"}, 1, "open /does/not/exist.json", "gitlab-bot validate-config: found"},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := runCommand(test.args, &stdout, &stderr); code != test.code {
+			t.Errorf("%v: returned wrong exit code: got %d want %d", test.args, code, test.code)
+		}
+		if !strings.Contains(stdout.String(), test.stdout) {
+			t.Errorf("%v: stdout %q doesn't include %q", test.args, stdout.String(), test.stdout)
+		}
+		if !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("%v: stderr %q doesn't include %q", test.args, stderr.String(), test.stderr)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(`{"projects": {"gitlab-org/gitlab-test": {"channel": "#gitlab-test"}}}`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	defer setenv(validEnvironment)()
+
+	var stdout bytes.Buffer
+	if code := runCommand([]string{"validate-config", "-config", file.Name()}, &stdout, ioutil.Discard); code != 0 {
+		t.Errorf("returned wrong exit code: got %d want 0: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "is valid: 1 projects") {
+		t.Errorf("wrong output: %q", stdout.String())
+	}
+}
+
+func TestValidateConfigWithoutAConfigFile(t *testing.T) {
+	defer setenv(validEnvironment)()
+
+	var stdout bytes.Buffer
+	if code := runCommand([]string{"validate-config"}, &stdout, ioutil.Discard); code != 0 {
+		t.Errorf("returned wrong exit code: got %d want 0: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "The default config is valid: 0 projects") {
+		t.Errorf("wrong output: %q", stdout.String())
+	}
+}
+
+func TestValidateConfigReportsEveryProblem(t *testing.T) {
+	defer setenv(map[string]string{
+		"CONFIG_PATH":           "",
+		"SLACK_TOKEN":           "",
+		"GITLAB_TOKEN":          "gitlab-token",
+		"ACTIVE_USERS":          ",",
+		"CAPTURE_MAX_MB":        "lots",
+		"WEBHOOK_ALLOWED_CIDRS": "10.0.0.0/33",
+		"TRUSTED_PROXIES":       "",
+		"SSL_CERT_PATH":         "/does/not/exist.crt",
+		"SSL_KEY_PATH":          "/does/not/exist.key",
+		"TLS_CLIENT_CA_PATH":    "",
+		"TLS_REQUIRED":          "true",
+	})()
+
+	var stdout, stderr bytes.Buffer
+	if code := runCommand([]string{"validate-config"}, &stdout, &stderr); code != 1 {
+		t.Errorf("returned wrong exit code: got %d want 1", code)
+	}
+
+	expected := "SLACK_TOKEN must not be empty\n" +
+		"ACTIVE_USERS must not be empty\n" +
+		"CAPTURE_MAX_MB must be a positive number\n" +
+		"WEBHOOK_ALLOWED_CIDRS: invalid CIDR address: 10.0.0.0/33\n" +
+		"TLS_REQUIRED is set but SSL_CERT_PATH and SSL_KEY_PATH don't both exist\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output: got %q want %q", stdout.String(), expected)
+	}
+	if !strings.Contains(stderr.String(), "found 5 problems") {
+		t.Errorf("wrong error: %q", stderr.String())
+	}
+}
+
+// validEnvironment is the least serve needs to start.
+var validEnvironment = map[string]string{
+	"CONFIG_PATH":           "",
+	"SLACK_TOKEN":           "slack-token",
+	"GITLAB_TOKEN":          "gitlab-token",
+	"ACTIVE_USERS":          "smeriwether1,smeriwether2",
+	"CAPTURE_MAX_MB":        "",
+	"WEBHOOK_ALLOWED_CIDRS": "",
+	"TRUSTED_PROXIES":       "",
+	"SSL_CERT_PATH":         "",
+	"SSL_KEY_PATH":          "",
+	"TLS_CLIENT_CA_PATH":    "",
+	"TLS_REQUIRED":          "",
+}
+
+// setenv sets the environment variables, the returned function puts back what was there before.
+func setenv(values map[string]string) func() {
+	previous := map[string]string{}
+	for name, value := range values {
+		previous[name] = os.Getenv(name)
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range previous {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func TestSyncUsers(t *testing.T) {
+	defer func(previous SlackReadWriter) { slackClient = previous }(slackClient)
+	defer func(previous GitlabReadWriter) { gitlabClient = previous }(gitlabClient)
+	slackClient = &slackDirectoryStub{}
+	gitlabClient = &gitlabClientStub{users: []User{
+		{Email: "stephen2@molecule.io", GitlabID: 2, GitlabUsername: "smeriwether2"},
+		{Email: "nobody@molecule.io", GitlabID: 3, GitlabUsername: "nobody"},
+	}}
+
+	var stdout bytes.Buffer
+	if code := runCommand([]string{"sync-users"}, &stdout, ioutil.Discard); code != 0 {
+		t.Errorf("returned wrong exit code: got %d want 0", code)
+	}
+
+	expected := "Matched 1 users:\n" +
+		"  smeriwether2 -> @smeriwether2 (stephen2@molecule.io)\n" +
+		"1 Gitlab users without a Slack account with the same email:\n" +
+		"  nobody (nobody@molecule.io)\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output: got %q want %q", stdout.String(), expected)
+	}
+}
+
+func TestSendTest(t *testing.T) {
+	defer func(previous SlackReadWriter) { slackClient = previous }(slackClient)
+	defer func(previous GitlabReadWriter) { gitlabClient = previous }(gitlabClient)
+	defer func(previous *[]User) { users = previous }(users)
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	defer func(previous *Config) { config = previous }(config)
+	config = &Config{CommentBatchSeconds: 10}
+	slackStub := slackDirectoryStub{}
+	slackClient = &slackStub
+	gitlabClient = &gitlabClientStub{users: *users}
+
+	var stdout, stderr bytes.Buffer
+	if code := runCommand([]string{"send-test", "smeriwether2"}, &stdout, &stderr); code != 0 {
+		t.Errorf("returned wrong exit code: got %d want 0: %s", code, stderr.String())
+	}
+
+	if stdout.String() != "Sent a test message to smeriwether2 (DSLACKID2)\n" {
+		t.Errorf("wrong output: %q", stdout.String())
+	}
+	if !strings.HasPrefix(slackStub.receivedMessage, "gitlab-bot made a comment on your <") {
+		t.Errorf("slack client received wrong message: %q", slackStub.receivedMessage)
+	}
+}
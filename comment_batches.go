@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+// excerptLength is how much of each comment makes it into a batched message.
+const excerptLength = 80
+
+var pendingComments = &commentBatcher{batches: map[commentBatchKey]*commentBatch{}}
+
+type commentBatchKey struct {
+	Reviewer        int
+	ProjectID       int
+	MergeRequestIID int
+}
+
+type commentBatch struct {
+	reviewer  *User
+	receivers []*User
+	events    []*webhook.NoteEvent
+	timer     *time.Timer
+}
+
+// commentBatcher collects the comments a reviewer leaves on a merge request in quick succession.
+type commentBatcher struct {
+	mu      sync.Mutex
+	batches map[commentBatchKey]*commentBatch
+	// sending counts the messages of flushed batches that haven't gone out yet
+	sending sync.WaitGroup
+}
+
+// Add queues the comment, the batch is sent once the reviewer hasn't commented for the window.
+func (b *commentBatcher) Add(window time.Duration, reviewer *User, receivers []*User, event *webhook.NoteEvent) {
+	key := commentBatchKey{
+		Reviewer:        event.ObjectAttributes.AuthorID,
+		ProjectID:       noteProjectID(event),
+		MergeRequestIID: event.MergeRequest.IID,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if batch, found := b.batches[key]; found {
+		batch.events = append(batch.events, event)
+		// A batch that's already being flushed takes this comment with it
+		if batch.timer.Stop() {
+			batch.timer.Reset(window)
+		}
+		return
+	}
+
+	batch := &commentBatch{reviewer: reviewer, receivers: receivers, events: []*webhook.NoteEvent{event}}
+	batch.timer = time.AfterFunc(window, func() { b.flush(key) })
+	b.batches[key] = batch
+}
+
+// FlushAll sends every batch right away and waits for them to go out, for when the bot stops.
+func (b *commentBatcher) FlushAll() {
+	b.mu.Lock()
+	var keys []commentBatchKey
+	for key, batch := range b.batches {
+		batch.timer.Stop()
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+
+	for _, key := range keys {
+		b.flush(key)
+	}
+	b.sending.Wait()
+}
+
+func (b *commentBatcher) flush(key commentBatchKey) {
+	b.mu.Lock()
+	batch := b.batches[key]
+	delete(b.batches, key)
+	if batch != nil {
+		b.sending.Add(len(batch.receivers))
+	}
+	b.mu.Unlock()
+
+	if batch == nil {
+		return
+	}
+	deliverEach(batch.receivers, func(receiver *User) {
+		defer b.sending.Done()
+		if len(batch.events) == 1 {
+			sendComment(batch.reviewer, []*User{receiver}, batch.events[0])
+			return
+		}
+		sendCommentBatch(batch, receiver)
+	})
+}
+
+// sendCommentBatch tells the receiver about every comment in the batch with a single message.
+func sendCommentBatch(batch *commentBatch, receiver *User) {
+	first := batch.events[0]
+	url := first.MergeRequest.URL
+	if url == "" {
+		// Older Gitlab versions leave out the merge request URL, the comment URL without its anchor will do
+		url = strings.SplitN(first.ObjectAttributes.URL, "#", 2)[0]
+	}
+	message := fmt.Sprintf(
+		"%s left %d comments on your <%s|Merge Request>",
+		batch.reviewer.GitlabUsername, len(batch.events), url,
+	)
+
+	var lines []string
+	for _, event := range batch.events {
+		lines = append(lines, commentSummary(event))
+	}
+
+	log.Println("Sending slack message")
+	log.Println(message)
+
+	projectID := noteProjectID(first)
+	var actions []MessageAction
+	if interactive() && canApprove(receiver, first.MergeRequest) {
+		actions = append(actions, approveMergeRequestAction(projectID, first.MergeRequest.IID))
+	}
+
+	// Replies in the Slack thread are posted to the merge request, there's no single discussion to reply to
+	target := NoteTarget{ProjectID: projectID, MergeRequestIID: first.MergeRequest.IID}
+	channel, timestamp := slackClient.PostInteractiveMessage(receiver.SlackID, message, strings.Join(lines, "\n"), actions)
+	for _, event := range batch.events {
+		recordNoteDecision(event, receiver, sentOrFailed(channel), "batched")
+	}
+	if channel != "" && projectID != 0 {
+		commentThreads.Remember(channel, timestamp, target)
+	}
+}
+
+// commentSummary is a line linking to the comment with where it was left and how it starts.
+func commentSummary(event *webhook.NoteEvent) string {
+	where := "comment"
+	if position := event.ObjectAttributes.Position; position != nil {
+		switch {
+		case position.NewLine != nil:
+			where = fmt.Sprintf("%s:%d", position.NewPath, *position.NewLine)
+		case position.OldLine != nil:
+			where = fmt.Sprintf("%s:%d", position.OldPath, *position.OldLine)
+		case position.NewPath != "":
+			where = position.NewPath
+		}
+	}
+
+	return fmt.Sprintf("• <%s|%s> %s", event.ObjectAttributes.URL, where, excerpt(event.ObjectAttributes.Note))
+}
+
+// excerpt is the first line of a comment, cut short if it's long.
+func excerpt(note string) string {
+	line := strings.TrimSpace(note)
+	cut := false
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line, cut = strings.TrimSpace(line[:i]), true
+	}
+	if runes := []rune(line); len(runes) > excerptLength {
+		line, cut = string(runes[:excerptLength]), true
+	}
+	if cut {
+		line += "…"
+	}
+	return line
+}
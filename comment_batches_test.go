@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCommentWebhookHandlerBatchesAReview(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+	config = &Config{CommentBatchSeconds: 1}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Note Hook", DiffCommentRequest(1245, "app/models/user.rb", 12, "Should this be private?"))
+	serveEvent(t, "Note Hook", DiffCommentRequest(1246, "app/models/user.rb", 40, "Typo in the method name"))
+	serveEvent(t, "Note Hook", MergeRequestCommentRequest())
+	time.Sleep(2 * time.Second) // Sleep to let the batch go out, this is a code smell :(
+
+	if !reflect.DeepEqual(slackStub.receivedChannels, []string{"SLACKID2"}) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, []string{"SLACKID2"})
+	}
+
+	expected := "smeriwether1 left 3 comments on your <http://example.com/gitlab-org/gitlab-test/merge_requests/1|Merge Request>"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+
+	expectedAttachment := "• <http://example.com/gitlab-org/gitlab-test/merge_requests/1#note_1245|app/models/user.rb:12> Should this be private?\n" +
+		"• <http://example.com/gitlab-org/gitlab-test/merge_requests/1#note_1246|app/models/user.rb:40> Typo in the method name\n" +
+		"• <http://example.com/gitlab-org/gitlab-test/merge_requests/1#note_1244|comment> This MR needs work."
+	if slackStub.receivedAttachment != expectedAttachment {
+		t.Errorf("slack client received wrong attachment: got %q want %q", slackStub.receivedAttachment, expectedAttachment)
+	}
+}
+
+func TestCommentWebhookHandlerSendsASingleBatchedCommentAsIs(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+	config = &Config{CommentBatchSeconds: 1}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Note Hook", MergeRequestCommentRequest())
+	time.Sleep(2 * time.Second) // Sleep to let the batch go out, this is a code smell :(
+
+	expected := "smeriwether1 made a comment on your <http://example.com/gitlab-org/gitlab-test/merge_requests/1#note_1244|Merge Request>"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+}
+
+func TestCommentWebhookHandlerWaitsForTheReviewerToStopCommenting(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+	config = &Config{CommentBatchSeconds: 1}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Note Hook", DiffCommentRequest(1245, "app/models/user.rb", 12, "Should this be private?"))
+	time.Sleep(700 * time.Millisecond)
+	serveEvent(t, "Note Hook", DiffCommentRequest(1246, "app/models/user.rb", 40, "Typo in the method name"))
+	time.Sleep(700 * time.Millisecond)
+
+	if len(slackStub.receivedChannels) != 0 {
+		t.Errorf("batch was sent while the reviewer was still commenting: %v", slackStub.receivedMessage)
+	}
+
+	time.Sleep(1 * time.Second) // Sleep to let the batch go out, this is a code smell :(
+
+	expected := "smeriwether1 left 2 comments on your <http://example.com/gitlab-org/gitlab-test/merge_requests/1|Merge Request>"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+}
+
+func TestCommentBatcherFlushAll(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+	config = &Config{CommentBatchSeconds: 60}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Note Hook", DiffCommentRequest(1245, "app/models/user.rb", 12, "Should this be private?"))
+	serveEvent(t, "Note Hook", DiffCommentRequest(1246, "app/models/user.rb", 40, "Typo in the method name"))
+	pendingComments.FlushAll()
+
+	if !reflect.DeepEqual(slackStub.receivedChannels, []string{"SLACKID2"}) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, []string{"SLACKID2"})
+	}
+	if len(pendingComments.batches) != 0 {
+		t.Errorf("batches are still pending: %v", pendingComments.batches)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		note     string
+		expected string
+	}{
+		{"Looks good", "Looks good"},
+		{"  First line\nsecond line", "First line…"},
+		{string(bytes.Repeat([]byte("a"), 100)), string(bytes.Repeat([]byte("a"), 80)) + "…"},
+	}
+
+	for _, test := range tests {
+		if got := excerpt(test.note); got != test.expected {
+			t.Errorf("excerpt(%q) = %q want %q", test.note, got, test.expected)
+		}
+	}
+}
+
+func DiffCommentRequest(id int, path string, line int, note string) []byte {
+	body := MergeRequestCommentRequest()
+	body = bytes.Replace(body, []byte(`"id": 1244`), []byte(fmt.Sprintf(`"id": %d`, id)), 1)
+	body = bytes.Replace(body, []byte(`note_1244`), []byte(fmt.Sprintf(`note_%d`, id)), 1)
+	body = bytes.Replace(body, []byte(`"note": "This MR needs work."`), []byte(fmt.Sprintf(`"note": %q`, note)), 1)
+	return bytes.Replace(body, []byte(`"st_diff": null,`), []byte(fmt.Sprintf(
+		`"position": {"position_type": "text", "old_path": %q, "new_path": %q, "old_line": null, "new_line": %d},`,
+		path, path, line,
+	)), 1)
+}
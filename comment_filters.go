@@ -0,0 +1,88 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+// ignoredComments counts the comments nobody was told about, keyed by why, see /debug/vars
+var ignoredComments = expvar.NewMap("ignored_comments")
+
+const (
+	ignoredSystemNote = "system_note"
+	ignoredBot        = "bot"
+	ignoredPattern    = "pattern"
+)
+
+// CommentFilters drop comments that aren't worth a notification.
+type CommentFilters struct {
+	// NotifySystemNotes lets Gitlab's own notes like "added 1 commit" through, they are dropped by default.
+	NotifySystemNotes bool `json:"notify_system_notes"`
+	// IgnoreAuthors are Gitlab usernames of bots (e.g. "danger-bot") whose comments are dropped.
+	IgnoreAuthors []string `json:"ignore_authors"`
+	// IgnorePatterns are regular expressions, comments matching any of them are dropped.
+	IgnorePatterns []string `json:"ignore_patterns"`
+
+	// patterns are IgnorePatterns compiled by compilePatterns. The config is shared by every worker, so they're
+	// never compiled while handling a comment.
+	patterns []*regexp.Regexp
+}
+
+// compilePatterns compiles IgnorePatterns, loadConfig does this once up front.
+func (filters *CommentFilters) compilePatterns() error {
+	patterns := make([]*regexp.Regexp, 0, len(filters.IgnorePatterns))
+	for i, pattern := range filters.IgnorePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("comment_filters.ignore_patterns[%d]: %v", i, err)
+		}
+		patterns = append(patterns, re)
+	}
+	filters.patterns = patterns
+	return nil
+}
+
+// ignoreReason returns why the comment should be dropped, it is empty if somebody should hear about it.
+func (filters *CommentFilters) ignoreReason(event *webhook.NoteEvent) string {
+	if event.ObjectAttributes.System && !filters.NotifySystemNotes {
+		return ignoredSystemNote
+	}
+
+	if event.User != nil {
+		for _, bot := range filters.IgnoreAuthors {
+			if strings.EqualFold(bot, event.User.Username) {
+				return ignoredBot
+			}
+		}
+	}
+
+	for _, pattern := range filters.patterns {
+		if pattern.MatchString(event.ObjectAttributes.Note) {
+			return ignoredPattern
+		}
+	}
+
+	return ""
+}
+
+// ignoredComment reports whether the comment is filtered out, logging and counting it if so.
+func ignoredComment(event *webhook.NoteEvent) bool {
+	reason := config.CommentFilters.ignoreReason(event)
+	if reason == "" {
+		return false
+	}
+
+	author := "someone"
+	if event.User != nil {
+		author = event.User.Username
+	}
+	log.Printf("Ignoring comment %d by %s: %s\n", event.ObjectAttributes.ID, author, reason)
+	ignoredComments.Add(reason, 1)
+	recordNoteDecision(event, &User{}, DecisionSuppressedFilter, reason)
+	return true
+}
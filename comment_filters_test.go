@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestCommentWebhookHandlerIgnoresFilteredComments(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+
+	tests := []struct {
+		name    string
+		filters CommentFilters
+		body    []byte
+		reason  string
+	}{
+		{
+			"system note",
+			CommentFilters{},
+			bytes.Replace(MergeRequestCommentRequest(), []byte(`"system": false`), []byte(`"system": true`), 1),
+			ignoredSystemNote,
+		},
+		{
+			"bot author",
+			CommentFilters{IgnoreAuthors: []string{"Root"}},
+			MergeRequestCommentRequest(),
+			ignoredBot,
+		},
+		{
+			"matching pattern",
+			CommentFilters{IgnorePatterns: []string{`^:warning:`, `needs\s+work`}},
+			MergeRequestCommentRequest(),
+			ignoredPattern,
+		},
+	}
+
+	for _, test := range tests {
+		config = &Config{CommentFilters: test.filters}
+		if err := config.CommentFilters.compilePatterns(); err != nil {
+			t.Fatal(err)
+		}
+		slackStub := slackClientStub{}
+		slackClient = &slackStub
+		before := ignoredCount(test.reason)
+
+		rr := serveEvent(t, "Note Hook", test.body)
+
+		if status := rr.Code; status != http.StatusAccepted {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.name, status, http.StatusAccepted)
+		}
+
+		if slackStub.receivedMessage != "" {
+			t.Errorf("%s: slack client received wrong message: got %v want (empty)", test.name, slackStub.receivedMessage)
+		}
+
+		if count := ignoredCount(test.reason) - before; count != 1 {
+			t.Errorf("%s: ignored_comments[%s] went up by %d want 1", test.name, test.reason, count)
+		}
+	}
+}
+
+func TestCommentWebhookHandlerNotifiesAboutSystemNotesWhenAsked(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+	config = &Config{CommentFilters: CommentFilters{
+		NotifySystemNotes: true,
+		IgnorePatterns:    []string{`^:warning:`},
+	}}
+	if err := config.CommentFilters.compilePatterns(); err != nil {
+		t.Fatal(err)
+	}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Note Hook", bytes.Replace(MergeRequestCommentRequest(), []byte(`"system": false`), []byte(`"system": true`), 1))
+
+	if slackStub.receivedChannel != "SLACKID2" {
+		t.Errorf("slack client received wrong channel: got %v want %v",
+			slackStub.receivedChannel, "SLACKID2")
+	}
+}
+
+func TestLoadConfigRejectsInvalidCommentPatterns(t *testing.T) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(`{"comment_filters": {"ignore_patterns": ["(unclosed"]}}`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if _, err := loadConfig(file.Name()); err == nil {
+		t.Error("loadConfig should reject an invalid comment pattern")
+	}
+}
+
+func ignoredCount(reason string) int64 {
+	if count, ok := ignoredComments.Get(reason).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDigestTime        = "09:00"
+	defaultStaleReminderTime = "10:00"
+	defaultStaleAfterDays    = 5
+	defaultEscalateAfterDays = 5
+	defaultSnoozeLabel       = "snoozed"
+	defaultLongJobMinutes    = 10
+	// defaultMaxBodyKB is far more than Gitlab sends, pushes list at most 20 commits
+	defaultMaxBodyKB = 1024
+)
+
+// Config holds the settings that don't fit in a single environment variable.
+// It is read from the JSON file at CONFIG_PATH, every field is optional.
+type Config struct {
+	// Timezone is used for anything scheduled by wall clock time, like digests.
+	Timezone string `json:"timezone"`
+	// DigestTime is when active users get their daily digest (e.g. "09:00").
+	DigestTime string `json:"digest_time"`
+	// Users holds per-user preferences keyed by Gitlab username.
+	Users map[string]UserConfig `json:"users"`
+	// Projects holds per-project settings keyed by path with namespace (e.g. "gitlab-org/gitlab-test").
+	Projects map[string]ProjectConfig `json:"projects"`
+
+	// StaleReminderTime is when authors and reviewers get nudged about stale merge requests.
+	StaleReminderTime string `json:"stale_reminder_time"`
+	// StaleAfterDays is how many business days without activity make a merge request stale.
+	StaleAfterDays int `json:"stale_after_days"`
+	// EscalateAfterDays is how many more business days until a stale merge request is posted to the project channel.
+	EscalateAfterDays int `json:"escalate_after_days"`
+	// SnoozeLabel stops the stale reminders for any merge request labeled with it.
+	SnoozeLabel string `json:"snooze_label"`
+
+	// DeploymentRoutes pick the channel each deployment is posted to, the first matching route wins.
+	DeploymentRoutes []DeploymentRoute `json:"deployment_routes"`
+
+	// LongJobMinutes is how long a job has to run before whoever started it is told it finished.
+	LongJobMinutes int `json:"long_job_minutes"`
+
+	// CommentBatchSeconds is how long to wait for more comments from the same reviewer on a merge request
+	// before sending them all in one message. Every comment starts the wait over. Comments are sent right away
+	// without it.
+	CommentBatchSeconds int `json:"comment_batch_seconds"`
+
+	// HistoryRetentionDays is how long webhooks and notification decisions are kept, see /admin/history.
+	HistoryRetentionDays int `json:"history_retention_days"`
+
+	// ProjectSecrets are webhook secret tokens keyed by Gitlab project ID. A project listed here only accepts
+	// its own tokens, so a token leaked from one project's hook settings can't be used to send events for another.
+	// Once any are set, events that don't say which project they're for are rejected.
+	ProjectSecrets map[int][]string `json:"project_secrets"`
+
+	// Workers is how many webhooks are handled, and how many Slack messages sent, at the same time. Webhooks are
+	// acknowledged as soon as they're checked and handled in the background, a negative value handles them
+	// while Gitlab waits instead.
+	Workers int `json:"workers"`
+
+	// MaxBodyKB is the largest request body read, anything bigger is rejected with a 413.
+	MaxBodyKB int `json:"max_body_kb"`
+
+	// CommentFilters drop system notes, bot comments and anything else nobody needs a DM about.
+	CommentFilters CommentFilters `json:"comment_filters"`
+
+	location *time.Location
+}
+
+type UserConfig struct {
+	// DigestTime overrides Config.DigestTime, "off" disables the digest.
+	DigestTime string `json:"digest_time"`
+	// NotifyOnDraft set to false stops comments and pipeline failures on the user's draft merge requests
+	// from being sent to them, they are sent by default.
+	NotifyOnDraft *bool `json:"notify_on_draft"`
+}
+
+type ProjectConfig struct {
+	// Channel is where project wide notifications like stale merge request escalations are posted.
+	Channel string `json:"channel"`
+	// ReleaseChannels get an announcement whenever a tag is pushed.
+	ReleaseChannels []string `json:"release_channels"`
+	// ProtectedForcePushesOnly only alerts on force pushes to protected branches, for projects where
+	// rewriting feature branches is how people work.
+	ProtectedForcePushesOnly bool `json:"protected_force_pushes_only"`
+	// WikiChannel is where wiki page changes are posted, it defaults to Channel.
+	WikiChannel string `json:"wiki_channel"`
+}
+
+func (project ProjectConfig) WikiChannelOrDefault() string {
+	if project.WikiChannel != "" {
+		return project.WikiChannel
+	}
+	return project.Channel
+}
+
+// DeploymentRoute sends deployments of matching projects to matching environments to a channel.
+// Project and Environment can use wildcards like "gitlab-org/*" or "review/*", empty matches everything.
+type DeploymentRoute struct {
+	Project     string `json:"project"`
+	Environment string `json:"environment"`
+	Channel     string `json:"channel"`
+}
+
+func (route DeploymentRoute) matches(project, environment string) bool {
+	return matchPattern(route.Project, project) && matchPattern(route.Environment, environment)
+}
+
+func loadConfig(path string) (*Config, error) {
+	cfg := Config{}
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		if err := json.NewDecoder(file).Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("decoding %s: %v", path, err)
+		}
+	}
+
+	if cfg.DigestTime == "" {
+		cfg.DigestTime = defaultDigestTime
+	}
+	if cfg.StaleReminderTime == "" {
+		cfg.StaleReminderTime = defaultStaleReminderTime
+	}
+	if cfg.StaleAfterDays == 0 {
+		cfg.StaleAfterDays = defaultStaleAfterDays
+	}
+	if cfg.EscalateAfterDays == 0 {
+		cfg.EscalateAfterDays = defaultEscalateAfterDays
+	}
+	if cfg.SnoozeLabel == "" {
+		cfg.SnoozeLabel = defaultSnoozeLabel
+	}
+	if cfg.LongJobMinutes == 0 {
+		cfg.LongJobMinutes = defaultLongJobMinutes
+	}
+	if cfg.HistoryRetentionDays == 0 {
+		cfg.HistoryRetentionDays = defaultHistoryRetentionDays
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.MaxBodyKB == 0 {
+		cfg.MaxBodyKB = defaultMaxBodyKB
+	}
+
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	cfg.location = location
+
+	if _, _, err := parseClock(cfg.DigestTime); err != nil && cfg.DigestTime != "off" {
+		return nil, err
+	}
+	if _, _, err := parseClock(cfg.StaleReminderTime); err != nil && cfg.StaleReminderTime != "off" {
+		return nil, err
+	}
+	for username, u := range cfg.Users {
+		if u.DigestTime == "" || u.DigestTime == "off" {
+			continue
+		}
+		if _, _, err := parseClock(u.DigestTime); err != nil {
+			return nil, fmt.Errorf("users.%s: %v", username, err)
+		}
+	}
+
+	for i, route := range cfg.DeploymentRoutes {
+		for _, pattern := range []string{route.Project, route.Environment} {
+			if !validPattern(pattern) {
+				return nil, fmt.Errorf("deployment_routes[%d]: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+
+	for id, secrets := range cfg.ProjectSecrets {
+		for _, secret := range secrets {
+			if strings.TrimSpace(secret) == "" {
+				return nil, fmt.Errorf("project_secrets.%d: secrets must not be empty", id)
+			}
+		}
+	}
+
+	if err := cfg.CommentFilters.compilePatterns(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (cfg *Config) Location() *time.Location {
+	if cfg.location == nil {
+		return time.Local
+	}
+	return cfg.location
+}
+
+// DigestTimeFor returns the hour and minute the user wants their digest, ok is false if they don't want one.
+func (cfg *Config) DigestTimeFor(username string) (hour, minute int, ok bool) {
+	clock := cfg.DigestTime
+	if u, found := cfg.Users[username]; found && u.DigestTime != "" {
+		clock = u.DigestTime
+	}
+
+	hour, minute, err := parseClock(clock)
+	if err != nil {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// NotifyOnDraft returns whether the user wants to hear about activity on their draft merge requests.
+func (cfg *Config) NotifyOnDraft(username string) bool {
+	if u, found := cfg.Users[username]; found && u.NotifyOnDraft != nil {
+		return *u.NotifyOnDraft
+	}
+	return true
+}
+
+// LongJob returns how long a job has to run to be worth a notification when it finishes.
+func (cfg *Config) LongJob() time.Duration {
+	if cfg.LongJobMinutes <= 0 {
+		return defaultLongJobMinutes * time.Minute
+	}
+	return time.Duration(cfg.LongJobMinutes) * time.Minute
+}
+
+// HistoryRetention returns how long history records are kept.
+func (cfg *Config) HistoryRetention() time.Duration {
+	if cfg.HistoryRetentionDays <= 0 {
+		return defaultHistoryRetentionDays * 24 * time.Hour
+	}
+	return time.Duration(cfg.HistoryRetentionDays) * 24 * time.Hour
+}
+
+// MaxBodyBytes returns how much of a request body is read at most.
+func (cfg *Config) MaxBodyBytes() int64 {
+	if cfg == nil || cfg.MaxBodyKB <= 0 {
+		return defaultMaxBodyKB * 1024
+	}
+	return int64(cfg.MaxBodyKB) * 1024
+}
+
+// CommentBatchWindow returns how long comments are collected before they are sent, zero if they aren't batched.
+func (cfg *Config) CommentBatchWindow() time.Duration {
+	if cfg.CommentBatchSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.CommentBatchSeconds) * time.Second
+}
+
+// DeploymentChannel returns the channel of the first deployment route matching the project and environment.
+func (cfg *Config) DeploymentChannel(project, environment string) string {
+	for _, route := range cfg.DeploymentRoutes {
+		if route.matches(project, environment) {
+			return route.Channel
+		}
+	}
+	return ""
+}
+
+// ProjectChannel returns the Slack channel configured for a project, if any.
+func (cfg *Config) ProjectChannel(path string) string {
+	return cfg.Projects[path].Channel
+}
+
+func (cfg *Config) Project(path string) ProjectConfig {
+	return cfg.Projects[path]
+}
+
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func validPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// parseClock parses a 24 hour "15:04" style time of day.
+func parseClock(clock string) (int, int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time of day %q, expected HH:MM", clock)
+	}
+	return t.Hour(), t.Minute(), nil
+}
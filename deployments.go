@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// Deployment is the subset of a Gitlab deployment the bot cares about.
+type Deployment struct {
+	ID     int
+	SHA    string
+	Status string
+}
+
+type listDeploymentsQuery struct {
+	gitlab.ListOptions
+	Environment string `url:"environment,omitempty"`
+	OrderBy     string `url:"order_by,omitempty"`
+	Sort        string `url:"sort,omitempty"`
+}
+
+type deploymentResponse struct {
+	ID     int    `json:"id"`
+	SHA    string `json:"sha"`
+	Status string `json:"status"`
+}
+
+func validateDeployment(event *webhook.DeploymentEvent) error {
+	if event.Project == nil || event.DeploymentID == 0 {
+		return errors.New("Not valid or not a deployment request")
+	}
+	return nil
+}
+
+func handleDeployment(w http.ResponseWriter, event *webhook.DeploymentEvent) {
+	if err := validateDeployment(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Only finished deployments are worth telling anyone about
+	if event.Status != "success" && event.Status != "failed" {
+		writeIgnored(w, "Deployment isn't finished")
+		return
+	}
+
+	deliver(event.Project.PathWithNamespace, func() { announceDeployment(event) })
+	notifyDeploymentAuthors(event)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// announceDeployment posts the deployment to the channel the routing rules pick for its environment.
+func announceDeployment(event *webhook.DeploymentEvent) {
+	channel := config.DeploymentChannel(event.Project.PathWithNamespace, event.Environment)
+	if channel == "" {
+		return
+	}
+
+	verb := "deployed"
+	if event.Status == "failed" {
+		verb = ":x: failed to deploy"
+	}
+
+	message := fmt.Sprintf(
+		"%s %s <%s|%s> of <%s|%s> to %s",
+		deployerName(event), verb, event.CommitURL, event.ShortSHA,
+		event.Project.WebURL, event.Project.PathWithNamespace, environmentLink(event),
+	)
+
+	log.Printf("Announcing deployment %d to %s\n", event.DeploymentID, channel)
+	posted, _ := slackClient.PostMessage(channel, message, event.CommitTitle)
+	recordEventDecision(webhook.KindDeployment, event, channel, sentOrFailed(posted), "")
+}
+
+// notifyDeploymentAuthors DMs the authors of every commit since the previous successful deployment.
+// The commits are looked up right away, each DM is delivered in order with everything else the author gets.
+func notifyDeploymentAuthors(event *webhook.DeploymentEvent) {
+	commits, err := deployedCommits(event)
+	if err != nil {
+		log.Println("Error finding deployed commits:", err)
+		return
+	}
+
+	var recipients []*User
+	titles := map[string][]string{}
+	// Authors nobody tells are only recorded once no matter how many of the commits are theirs
+	skipped := map[string]bool{}
+	for _, commit := range commits {
+		author := findUserByEmail(commit.AuthorEmail)
+		if author == nil {
+			if !skipped[commit.AuthorEmail] {
+				skipped[commit.AuthorEmail] = true
+				recordEventDecision(webhook.KindDeployment, event, commit.AuthorEmail, DecisionSuppressedUnknown,
+					"no Slack account for the commit author")
+			}
+			continue
+		}
+		if !activeUser(author) {
+			if !skipped[author.GitlabUsername] {
+				skipped[author.GitlabUsername] = true
+				recordEventDecision(webhook.KindDeployment, event, author.GitlabUsername, DecisionSuppressedInactive, "")
+			}
+			continue
+		}
+		if _, seen := titles[author.GitlabUsername]; !seen {
+			recipients = append(recipients, author)
+		}
+		titles[author.GitlabUsername] = append(titles[author.GitlabUsername], "• "+commit.Title)
+	}
+
+	message := fmt.Sprintf("Your changes in <%s|%s> were deployed to %s by %s",
+		event.Project.WebURL, event.Project.PathWithNamespace, environmentLink(event), deployerName(event))
+	if event.Status == "failed" {
+		message = fmt.Sprintf(":x: Deploying your changes in <%s|%s> to %s failed",
+			event.Project.WebURL, event.Project.PathWithNamespace, environmentLink(event))
+	}
+
+	deliverEach(recipients, func(author *User) {
+		log.Printf("Telling %s about deployment %d\n", author.GitlabUsername, event.DeploymentID)
+		channel, _ := slackClient.PostMessage(author.SlackID, message, strings.Join(titles[author.GitlabUsername], "\n"))
+		recordEventDecision(webhook.KindDeployment, event, author.GitlabUsername, sentOrFailed(channel), "")
+	})
+}
+
+// deployedCommits compares the deployment with the previous successful one to the same environment.
+// The first deployment to an environment would include the whole history, so nobody is told about it.
+func deployedCommits(event *webhook.DeploymentEvent) ([]Commit, error) {
+	deployments, err := gitlabClient.ListDeployments(event.Project.ID, event.Environment)
+	if err != nil || deployments == nil {
+		return nil, err
+	}
+
+	to := event.ShortSHA
+	from := ""
+	for _, deployment := range *deployments {
+		if deployment.ID == event.DeploymentID && deployment.SHA != "" {
+			to = deployment.SHA
+		}
+		if deployment.ID < event.DeploymentID && deployment.Status == "success" && from == "" {
+			from = deployment.SHA
+		}
+	}
+
+	if from == "" || from == to {
+		return nil, nil
+	}
+
+	commits, err := gitlabClient.CompareCommits(event.Project.ID, from, to)
+	if err != nil || commits == nil {
+		return nil, err
+	}
+	return *commits, nil
+}
+
+func deployerName(event *webhook.DeploymentEvent) string {
+	if event.User == nil {
+		return "Someone"
+	}
+	return event.User.Username
+}
+
+func environmentLink(event *webhook.DeploymentEvent) string {
+	if event.EnvironmentExternalURL == "" {
+		return fmt.Sprintf("`%s`", event.Environment)
+	}
+	return fmt.Sprintf("<%s|%s>", event.EnvironmentExternalURL, event.Environment)
+}
+
+// ListDeployments returns the most recent deployments to an environment, newest first.
+func (client *GitlabClient) ListDeployments(projectID int, environment string) (*[]Deployment, error) {
+	query := listDeploymentsQuery{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Environment: environment,
+		OrderBy:     "id",
+		Sort:        "desc",
+	}
+
+	req, err := client.client.NewRequest("GET", fmt.Sprintf("projects/%d/deployments", projectID), &query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var page []deploymentResponse
+	if _, err := client.client.Do(req, &page); err != nil {
+		return nil, err
+	}
+
+	var deployments []Deployment
+	for _, d := range page {
+		deployments = append(deployments, Deployment{ID: d.ID, SHA: d.SHA, Status: d.Status})
+	}
+	return &deployments, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandlerWithASuccessfulDeployment(t *testing.T) {
+	config = &Config{DeploymentRoutes: []DeploymentRoute{
+		{Environment: "production", Channel: "#production"},
+		{Project: "root/*", Channel: "#deployments"},
+	}}
+	gitlabStub := gitlabClientStub{
+		deployments: []Deployment{
+			{ID: 15, SHA: "279484c09fbe69ededfced8c1bb6e6d24616b468", Status: "success"},
+			{ID: 14, SHA: "1111111111111111111111111111111111111111", Status: "failed"},
+			{ID: 12, SHA: "2222222222222222222222222222222222222222", Status: "success"},
+		},
+		compareCommits: []Commit{
+			{Title: "Add new file", AuthorEmail: "stephen1@molecule.io"},
+			{Title: "Fix the build", AuthorEmail: "stephen1@molecule.io"},
+			{Title: "Update README", AuthorEmail: "someone@example.com"},
+		},
+	}
+	gitlabClient = &gitlabStub
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	rr := serveEvent(t, "Deployment Hook", DeploymentRequest("success"))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	expectedCalls := []string{"compare 30 2222222222222222222222222222222222222222...279484c09fbe69ededfced8c1bb6e6d24616b468"}
+	if !reflect.DeepEqual(gitlabStub.calls, expectedCalls) {
+		t.Errorf("gitlab client received wrong calls: got %v want %v", gitlabStub.calls, expectedCalls)
+	}
+
+	// The announcement and the DMs are delivered separately, in no particular order
+	channels := append([]string{}, slackStub.receivedChannels...)
+	sort.Strings(channels)
+	expectedChannels := []string{"#deployments", "SLACKID1"}
+	if !reflect.DeepEqual(channels, expectedChannels) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, expectedChannels)
+	}
+
+	message, attachment := messageTo(&slackStub, "SLACKID1")
+	if !strings.Contains(message, "deployed to <https://staging.example.com|staging> by root") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			message, "deployed to <https://staging.example.com|staging> by root")
+	}
+
+	if attachment != "• Add new file\n• Fix the build" {
+		t.Errorf("slack client received wrong attachment: got %q want %q",
+			attachment, "• Add new file\n• Fix the build")
+	}
+}
+
+func TestWebhookHandlerWithAFailedDeployment(t *testing.T) {
+	config = &Config{DeploymentRoutes: []DeploymentRoute{{Channel: "#deployments"}}}
+	gitlabClient = &gitlabClientStub{
+		deployments: []Deployment{
+			{ID: 15, SHA: "279484c09fbe69ededfced8c1bb6e6d24616b468", Status: "failed"},
+			{ID: 12, SHA: "2222222222222222222222222222222222222222", Status: "success"},
+		},
+		compareCommits: []Commit{{Title: "Add new file", AuthorEmail: "stephen2@molecule.io"}},
+	}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Deployment Hook", DeploymentRequest("failed"))
+
+	channels := append([]string{}, slackStub.receivedChannels...)
+	sort.Strings(channels)
+	expectedChannels := []string{"#deployments", "SLACKID2"}
+	if !reflect.DeepEqual(channels, expectedChannels) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, expectedChannels)
+	}
+
+	if message, _ := messageTo(&slackStub, "SLACKID2"); !strings.Contains(message, "to <https://staging.example.com|staging> failed") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			message, "to <https://staging.example.com|staging> failed")
+	}
+}
+
+func TestWebhookHandlerWithTheFirstDeploymentToAnEnvironment(t *testing.T) {
+	config = &Config{}
+	gitlabStub := gitlabClientStub{
+		deployments: []Deployment{{ID: 15, SHA: "279484c09fbe69ededfced8c1bb6e6d24616b468", Status: "success"}},
+	}
+	gitlabClient = &gitlabStub
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Deployment Hook", DeploymentRequest("success"))
+
+	if len(gitlabStub.calls) != 0 {
+		t.Errorf("gitlab client should not have been called: %v", gitlabStub.calls)
+	}
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestWebhookHandlerWithARunningDeployment(t *testing.T) {
+	config = &Config{DeploymentRoutes: []DeploymentRoute{{Channel: "#deployments"}}}
+	gitlabClient = &gitlabClientStub{}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Deployment Hook", DeploymentRequest("running"))
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestDeploymentDecisionsAreRecorded(t *testing.T) {
+	defer func(previous *HistoryStore) { history = previous }(history)
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	history = NewHistoryStore(time.Hour)
+	activeUsers = &[]User{{GitlabUsername: "smeriwether1"}}
+	config = &Config{DeploymentRoutes: []DeploymentRoute{{Channel: "#deployments"}}}
+	gitlabClient = &gitlabClientStub{
+		deployments: []Deployment{
+			{ID: 15, SHA: "279484c09fbe69ededfced8c1bb6e6d24616b468", Status: "success"},
+			{ID: 12, SHA: "2222222222222222222222222222222222222222", Status: "success"},
+		},
+		compareCommits: []Commit{
+			{Title: "Add new file", AuthorEmail: "stephen1@molecule.io"},
+			{Title: "Fix the build", AuthorEmail: "stephen2@molecule.io"},
+			{Title: "Update README", AuthorEmail: "someone@example.com"},
+			{Title: "Update README again", AuthorEmail: "someone@example.com"},
+		},
+	}
+	slackClient = &slackClientStub{}
+
+	serveEvent(t, "Deployment Hook", DeploymentRequest("success"))
+
+	var decisions []string
+	for _, record := range history.Query(HistoryQuery{Project: "root/test-deployment-webhooks"}) {
+		decisions = append(decisions, record.User+" "+record.Decision)
+	}
+	sort.Strings(decisions)
+	expected := []string{
+		" " + DecisionReceived,
+		"#deployments " + DecisionSent,
+		"smeriwether1 " + DecisionSent,
+		"smeriwether2 " + DecisionSuppressedInactive,
+		"someone@example.com " + DecisionSuppressedUnknown,
+	}
+	if !reflect.DeepEqual(decisions, expected) {
+		t.Errorf("recorded wrong decisions: got %v want %v", decisions, expected)
+	}
+}
+
+func TestDeploymentChannelUsesTheFirstMatchingRoute(t *testing.T) {
+	cfg := Config{DeploymentRoutes: []DeploymentRoute{
+		{Project: "gitlab-org/*", Environment: "review/*", Channel: ""},
+		{Environment: "production", Channel: "#production"},
+		{Project: "gitlab-org/*", Channel: "#gitlab-org"},
+	}}
+
+	tests := []struct {
+		project     string
+		environment string
+		channel     string
+	}{
+		{"gitlab-org/gitlab-test", "production", "#production"},
+		{"gitlab-org/gitlab-test", "staging", "#gitlab-org"},
+		{"gitlab-org/gitlab-test", "review/my-branch", ""},
+		{"mike/diaspora", "staging", ""},
+	}
+
+	for _, test := range tests {
+		if channel := cfg.DeploymentChannel(test.project, test.environment); channel != test.channel {
+			t.Errorf("DeploymentChannel(%q, %q) = %q want %q", test.project, test.environment, channel, test.channel)
+		}
+	}
+}
+
+// messageTo returns the last message and attachment posted to the channel.
+func messageTo(stub *slackClientStub, channel string) (string, string) {
+	for i := len(stub.receivedChannels) - 1; i >= 0; i-- {
+		if stub.receivedChannels[i] == channel {
+			return stub.receivedMessages[i], stub.receivedAttachments[i]
+		}
+	}
+	return "", ""
+}
+
+func DeploymentRequest(status string) []byte {
+	return []byte(fmt.Sprintf(
+		`
+	{
+		"object_kind": "deployment",
+		"status": %q,
+		"status_changed_at": "2021-04-28 21:50:00 +0200",
+		"deployment_id": 15,
+		"deployable_id": 796,
+		"deployable_url": "http://10.126.0.2:3000/root/test-deployment-webhooks/-/jobs/796",
+		"environment": "staging",
+		"environment_slug": "staging",
+		"environment_external_url": "https://staging.example.com",
+		"project": {
+			"id": 30,
+			"name": "test-deployment-webhooks",
+			"web_url": "http://10.126.0.2:3000/root/test-deployment-webhooks",
+			"path_with_namespace": "root/test-deployment-webhooks",
+			"default_branch": "master"
+		},
+		"short_sha": "279484c0",
+		"user": {
+			"id": 1,
+			"name": "Administrator",
+			"username": "root",
+			"email": "admin@example.com"
+		},
+		"user_url": "http://10.126.0.2:3000/root",
+		"commit_url": "http://10.126.0.2:3000/root/test-deployment-webhooks/-/commit/279484c09fbe69ededfced8c1bb6e6d24616b468",
+		"commit_title": "Add new file",
+		"ref": "master"
+	}
+	`,
+		status,
+	))
+}
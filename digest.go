@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// digestsSent remembers the day each user last got a digest so a slow tick can't double send. It's only kept
+// in memory, restarting within the hour after a digest went out sends it again.
+var digestsSent = map[string]string{}
+
+// sendDueDigests is called every minute and sends digests to active users whose digest time has come up.
+// Digests are only sent on weekdays and within an hour of the configured time.
+func sendDueDigests(now time.Time) {
+	if users == nil || activeUsers == nil {
+		return
+	}
+
+	now = now.In(config.Location())
+	if !businessDay(now) {
+		return
+	}
+	today := now.Format("2006-01-02")
+
+	for _, active := range *activeUsers {
+		hour, minute, ok := config.DigestTimeFor(active.GitlabUsername)
+		if !ok {
+			continue
+		}
+		due := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if now.Before(due) || now.Sub(due) >= time.Hour {
+			continue
+		}
+		if digestsSent[active.GitlabUsername] == today {
+			continue
+		}
+
+		user := findUserByUsername(active.GitlabUsername)
+		if user == nil {
+			continue
+		}
+
+		digestsSent[active.GitlabUsername] = today
+		sendDigest(user, now)
+	}
+}
+
+func sendDigest(user *User, now time.Time) {
+	mergeRequests, err := mergeRequestsAwaiting(user)
+	if err != nil {
+		log.Printf("Unable to build digest for %s: %v\n", user.GitlabUsername, err)
+		return
+	}
+	if len(mergeRequests) == 0 {
+		log.Printf("Nothing to digest for %s\n", user.GitlabUsername)
+		return
+	}
+
+	var lines []string
+	for _, mr := range mergeRequests {
+		status, err := gitlabClient.GetMergeRequestStatus(mr.ProjectID, mr.IID)
+		if err != nil {
+			log.Println(err)
+			status = &MergeRequestStatus{}
+		}
+		lines = append(lines, digestLine(&mr, status, now))
+	}
+
+	message := fmt.Sprintf("Good morning! %d merge requests are waiting on you", len(mergeRequests))
+	if len(mergeRequests) == 1 {
+		message = "Good morning! 1 merge request is waiting on you"
+	}
+
+	log.Printf("Sending digest to %s\n", user.GitlabUsername)
+	channel, _ := slackClient.PostMessage(user.SlackID, message, strings.Join(lines, "\n"))
+	recordDecision(EventDigest, "", 0, user.GitlabUsername, "", sentOrFailed(channel), "")
+}
+
+// mergeRequestsAwaiting returns the open merge requests the user is assigned to or reviewing, oldest first.
+// Merge requests the user wrote themselves are left out since they aren't waiting on their review.
+func mergeRequestsAwaiting(user *User) ([]MergeRequest, error) {
+	assigned, err := gitlabClient.ListOpenMergeRequests(ListMergeRequestsOptions{AssigneeID: user.GitlabID})
+	if err != nil {
+		return nil, err
+	}
+	reviewing, err := gitlabClient.ListOpenMergeRequests(ListMergeRequestsOptions{ReviewerID: user.GitlabID})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	var mergeRequests []MergeRequest
+	for _, list := range []*[]MergeRequest{assigned, reviewing} {
+		if list == nil {
+			continue
+		}
+		for _, mr := range *list {
+			if seen[mr.ID] || mr.AuthorID == user.GitlabID {
+				continue
+			}
+			seen[mr.ID] = true
+			mergeRequests = append(mergeRequests, mr)
+		}
+	}
+
+	sort.Slice(mergeRequests, func(i, j int) bool {
+		return mergeRequests[i].CreatedAt.Before(mergeRequests[j].CreatedAt)
+	})
+
+	return mergeRequests, nil
+}
+
+func digestLine(mr *MergeRequest, status *MergeRequestStatus, now time.Time) string {
+	details := []string{fmt.Sprintf("opened %s", age(mr.CreatedAt, now))}
+	if status.PipelineStatus != "" {
+		details = append(details, fmt.Sprintf("pipeline %s", status.PipelineStatus))
+	}
+	switch status.UnresolvedDiscussions {
+	case 0:
+	case 1:
+		details = append(details, "1 unresolved thread")
+	default:
+		details = append(details, fmt.Sprintf("%d unresolved threads", status.UnresolvedDiscussions))
+	}
+
+	return fmt.Sprintf("• <%s|%s> (%s)", mr.WebURL, mergeRequestName(mr), strings.Join(details, ", "))
+}
+
+// age renders how long ago something happened in the coarse way people talk about merge requests.
+func age(then, now time.Time) string {
+	d := now.Sub(then)
+	switch {
+	case d < time.Hour:
+		return "just now"
+	case d < 24*time.Hour:
+		hours := int(d.Hours())
+		if hours == 1 {
+			return "1 hour ago"
+		}
+		return fmt.Sprintf("%d hours ago", hours)
+	default:
+		days := int(d.Hours() / 24)
+		if days == 1 {
+			return "1 day ago"
+		}
+		return fmt.Sprintf("%d days ago", days)
+	}
+}
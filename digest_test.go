@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendDueDigests(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = &[]User{
+		{GitlabUsername: "smeriwether1"},
+	}
+	config = &Config{DigestTime: "09:00", location: time.UTC}
+	digestsSent = map[string]string{}
+	gitlabStub := gitlabClientStub{
+		mergeRequests: []MergeRequest{
+			{
+				ID: 100, IID: 1, ProjectID: 10, AuthorID: 2, Title: "Add digests",
+				Reference: "gitlab-org/gitlab-test!1", WebURL: "http://example.com/gitlab-org/gitlab-test/merge_requests/1",
+				CreatedAt: time.Date(2017, 6, 5, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		status: MergeRequestStatus{PipelineStatus: "failed", UnresolvedDiscussions: 2},
+	}
+	gitlabClient = &gitlabStub
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	// Monday June 12th 2017, before the digest time
+	sendDueDigests(time.Date(2017, 6, 12, 8, 59, 0, 0, time.UTC))
+	if slackStub.receivedChannel != "" {
+		t.Errorf("digest sent too early to %v", slackStub.receivedChannel)
+	}
+
+	sendDueDigests(time.Date(2017, 6, 12, 9, 0, 0, 0, time.UTC))
+	if slackStub.receivedChannel != "SLACKID1" {
+		t.Errorf("slack client received wrong channel: got %v want %v",
+			slackStub.receivedChannel, "SLACKID1")
+	}
+
+	for _, want := range []string{"gitlab-org/gitlab-test!1 Add digests", "7 days ago", "pipeline failed", "2 unresolved threads"} {
+		if !strings.Contains(slackStub.receivedAttachment, want) {
+			t.Errorf("slack client received wrong attachment: got %v wanted to include %v",
+				slackStub.receivedAttachment, want)
+		}
+	}
+
+	slackStub.receivedChannel = ""
+	sendDueDigests(time.Date(2017, 6, 12, 9, 1, 0, 0, time.UTC))
+	if slackStub.receivedChannel != "" {
+		t.Errorf("digest sent twice to %v", slackStub.receivedChannel)
+	}
+
+	// Saturday
+	sendDueDigests(time.Date(2017, 6, 17, 9, 0, 0, 0, time.UTC))
+	if slackStub.receivedChannel != "" {
+		t.Errorf("digest sent on the weekend to %v", slackStub.receivedChannel)
+	}
+}
+
+func TestSendDueDigestsSkipsOwnMergeRequests(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = &[]User{
+		{GitlabUsername: "smeriwether2"},
+	}
+	config = &Config{DigestTime: "09:00", location: time.UTC}
+	digestsSent = map[string]string{}
+	gitlabClient = &gitlabClientStub{
+		mergeRequests: []MergeRequest{
+			{ID: 100, IID: 1, ProjectID: 10, AuthorID: 2, Title: "My own MR"},
+		},
+	}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	sendDueDigests(time.Date(2017, 6, 12, 9, 0, 0, 0, time.UTC))
+
+	if slackStub.receivedChannel != "" {
+		t.Errorf("slack client received wrong channel: got %v want (empty)", slackStub.receivedChannel)
+	}
+}
+
+func TestDigestTimeForUserPreference(t *testing.T) {
+	cfg := Config{
+		DigestTime: "09:00",
+		Users: map[string]UserConfig{
+			"smeriwether1": {DigestTime: "07:30"},
+			"smeriwether2": {DigestTime: "off"},
+		},
+	}
+
+	if hour, minute, ok := cfg.DigestTimeFor("smeriwether1"); !ok || hour != 7 || minute != 30 {
+		t.Errorf("wrong digest time: got %d:%d (%v) want 7:30", hour, minute, ok)
+	}
+	if _, _, ok := cfg.DigestTimeFor("smeriwether2"); ok {
+		t.Errorf("digest should be turned off")
+	}
+	if hour, minute, ok := cfg.DigestTimeFor("someone"); !ok || hour != 9 || minute != 0 {
+		t.Errorf("wrong digest time: got %d:%d (%v) want 9:00", hour, minute, ok)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dryRunBufferSize is how many of the latest messages a dry run keeps around for /admin/dry-run.
+const dryRunBufferSize = 200
+
+// SentMessage is a message a dry run would have sent.
+type SentMessage struct {
+	Time       time.Time `json:"time"`
+	Channel    string    `json:"channel"`
+	Message    string    `json:"message"`
+	Attachment string    `json:"attachment,omitempty"`
+	Actions    []string  `json:"actions,omitempty"`
+}
+
+// DryRunSlackClient prints messages instead of sending them and keeps the latest ones in memory.
+// Users still come from the directory client, so user discovery works like it does for real.
+type DryRunSlackClient struct {
+	mu        sync.Mutex
+	out       io.Writer
+	directory SlackReadWriter
+	sent      []SentMessage
+	next      int
+	messages  int
+}
+
+// NewDryRunSlackClient prints to out, directory can be nil when there is no Slack to look users up in.
+func NewDryRunSlackClient(out io.Writer, directory SlackReadWriter) *DryRunSlackClient {
+	return &DryRunSlackClient{out: out, directory: directory}
+}
+
+func (client *DryRunSlackClient) PostMessage(channel, message, attachment string) (string, string) {
+	return client.PostInteractiveMessage(channel, message, attachment, nil)
+}
+
+func (client *DryRunSlackClient) PostInteractiveMessage(channel, message, attachment string, actions []MessageAction) (string, string) {
+	sent := SentMessage{Time: time.Now(), Channel: channel, Message: message, Attachment: attachment}
+	for _, action := range actions {
+		sent.Actions = append(sent.Actions, action.Text)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	fmt.Fprintf(client.out, "-> %s: %s\n", channel, message)
+	for _, line := range strings.Split(attachment, "\n") {
+		if line != "" {
+			fmt.Fprintf(client.out, "   | %s\n", line)
+		}
+	}
+	for _, action := range sent.Actions {
+		fmt.Fprintf(client.out, "   [%s]\n", action)
+	}
+
+	if len(client.sent) < dryRunBufferSize {
+		client.sent = append(client.sent, sent)
+	} else {
+		client.sent[client.next] = sent
+	}
+	client.next = (client.next + 1) % dryRunBufferSize
+
+	client.messages++
+	return channel, fmt.Sprintf("%d.%06d", time.Now().Unix(), client.messages)
+}
+
+func (client *DryRunSlackClient) AddReaction(channel, timestamp, name string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	fmt.Fprintf(client.out, "-> %s: :%s: on %s\n", channel, name, timestamp)
+}
+
+func (client *DryRunSlackClient) ListUsers() (*[]User, error) {
+	if client.directory == nil {
+		return &[]User{}, nil
+	}
+	return client.directory.ListUsers()
+}
+
+// Sent returns the messages in the buffer, oldest first.
+func (client *DryRunSlackClient) Sent() []SentMessage {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	sent := make([]SentMessage, 0, len(client.sent))
+	if len(client.sent) < dryRunBufferSize {
+		return append(sent, client.sent...)
+	}
+	return append(append(sent, client.sent[client.next:]...), client.sent[:client.next]...)
+}
+
+// DryRunHandler lists what a dry run would have sent, newest last.
+func DryRunHandler(w http.ResponseWriter, r *http.Request) {
+	client, ok := slackClient.(*DryRunSlackClient)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		if _, err := w.Write([]byte("Not a dry run")); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(client.Sent()); err != nil {
+		log.Println(err)
+	}
+}
+
+// logWriter sends a dry run's output to the log.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	log.Print(string(p))
+	return len(p), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDryRunSlackClientKeepsTheLatestMessages(t *testing.T) {
+	var out bytes.Buffer
+	client := NewDryRunSlackClient(&out, nil)
+
+	for i := 0; i < dryRunBufferSize+5; i++ {
+		client.PostMessage("SLACKID1", fmt.Sprintf("message %d", i), "")
+	}
+
+	sent := client.Sent()
+	if len(sent) != dryRunBufferSize {
+		t.Fatalf("kept wrong number of messages: got %d want %d", len(sent), dryRunBufferSize)
+	}
+	if sent[0].Message != "message 5" {
+		t.Errorf("oldest message is wrong: got %q want %q", sent[0].Message, "message 5")
+	}
+	if last := sent[len(sent)-1].Message; last != fmt.Sprintf("message %d", dryRunBufferSize+4) {
+		t.Errorf("newest message is wrong: got %q", last)
+	}
+}
+
+func TestDryRunSlackClientLooksUpUsersInTheDirectory(t *testing.T) {
+	client := NewDryRunSlackClient(ioutil.Discard, &slackDirectoryStub{})
+
+	found, err := client.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || len(*found) != 2 {
+		t.Errorf("dry run client should list the directory's users, got %v", found)
+	}
+}
+
+func TestDryRunHandler(t *testing.T) {
+	defer func(previous SlackReadWriter) { slackClient = previous }(slackClient)
+	client := NewDryRunSlackClient(ioutil.Discard, nil)
+	slackClient = client
+	client.PostInteractiveMessage("SLACKID2", "smeriwether1 made a comment", "Looks good", []MessageAction{{Text: "Approve"}})
+
+	req, err := http.NewRequest("GET", "/admin/dry-run", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(DryRunHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var sent []SentMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &sent); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].Channel != "SLACKID2" || sent[0].Attachment != "Looks good" || sent[0].Actions[0] != "Approve" {
+		t.Errorf("handler returned wrong messages: got %+v", sent)
+	}
+}
+
+func TestDryRunHandlerWhenSendingForReal(t *testing.T) {
+	defer func(previous SlackReadWriter) { slackClient = previous }(slackClient)
+	slackClient = &slackClientStub{}
+
+	req, err := http.NewRequest("GET", "/admin/dry-run", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(DryRunHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+type slackDirectoryStub struct {
+	slackClientStub
+}
+
+func (stub *slackDirectoryStub) ListUsers() (*[]User, error) {
+	return users, nil
+}
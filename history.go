@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+const (
+	defaultHistoryRetentionDays = 30
+	defaultHistoryQueryLimit    = 100
+	// historyPruneInterval batches up expired records so they're dropped once an hour rather than one by one.
+	historyPruneInterval = time.Hour
+)
+
+// maxHistoryRecords caps the records kept in memory however busy the hooks are, the oldest are dropped first.
+// Only the records in memory can be queried, and the file is cut down to them the next time it's pruned.
+var maxHistoryRecords = 200000
+
+// Decisions of a HistoryRecord.
+const (
+	DecisionReceived           = "received"
+	DecisionSent               = "sent"
+	DecisionFailed             = "failed"
+	DecisionSuppressedInactive = "suppressed-inactive"
+	DecisionSuppressedSelf     = "suppressed-self"
+	DecisionSuppressedDraft    = "suppressed-draft"
+	DecisionSuppressedFilter   = "suppressed-filter"
+	DecisionSuppressedUnknown  = "suppressed-unknown-user"
+	// DecisionDropped is an event that was never handled because the workers were too busy.
+	DecisionDropped = "dropped"
+)
+
+// Events of a HistoryRecord that the bot sends on its own rather than because of a webhook.
+const (
+	EventDigest = "digest"
+	EventStale  = "stale"
+)
+
+// history is where webhooks and notification decisions are recorded, see /admin/history.
+var history = NewHistoryStore(defaultHistoryRetentionDays * 24 * time.Hour)
+
+// HistoryRecord is a webhook that came in or a decision about telling someone about it.
+type HistoryRecord struct {
+	Time         time.Time `json:"time"`
+	Event        string    `json:"event"`
+	Project      string    `json:"project,omitempty"`
+	MergeRequest int       `json:"merge_request,omitempty"`
+	// User is who was or wasn't told, Actor is who did the thing.
+	User     string `json:"user,omitempty"`
+	Actor    string `json:"actor,omitempty"`
+	Decision string `json:"decision"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// HistoryQuery filters records, zero values match everything.
+type HistoryQuery struct {
+	User         string
+	Project      string
+	MergeRequest int
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+func (q HistoryQuery) matches(record *HistoryRecord) bool {
+	return (q.User == "" || record.User == q.User || record.Actor == q.User) &&
+		(q.Project == "" || record.Project == q.Project) &&
+		(q.MergeRequest == 0 || record.MergeRequest == q.MergeRequest) &&
+		(q.Since.IsZero() || !record.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || record.Time.Before(q.Until))
+}
+
+// HistoryStore keeps records for the retention period in memory, and in a JSON lines file when it has a path.
+type HistoryStore struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	retention time.Duration
+	records   []HistoryRecord
+	// added counts the records added so far, prunedAt is when expired records were last dropped
+	added    int
+	prunedAt time.Time
+}
+
+// NewHistoryStore returns a store that only keeps records in memory.
+func NewHistoryStore(retention time.Duration) *HistoryStore {
+	return &HistoryStore{retention: retention}
+}
+
+// OpenHistoryStore loads the records in the file that are still within the retention period.
+func OpenHistoryStore(path string, retention time.Duration) (*HistoryStore, error) {
+	store := &HistoryStore{path: path, retention: retention}
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record HistoryRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				log.Println("Skipping broken history record:", err)
+				continue
+			}
+			store.records = append(store.records, record)
+		}
+		file.Close()
+		store.trim()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rewriting the file drops whatever expired while the bot wasn't running
+	if err := store.rewrite(time.Now()); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Add records something that happened just now.
+func (store *HistoryStore) Add(record HistoryRecord) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.records = append(store.records, record)
+	store.added++
+	store.trim()
+	if store.file == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println("Error recording history:", err)
+		return
+	}
+	if _, err := store.file.Write(append(line, '\n')); err != nil {
+		log.Println("Error recording history:", err)
+	}
+}
+
+// Prune drops the records older than the retention period. Expired records are dropped at most once every
+// historyPruneInterval, so the file isn't rewritten every time the oldest record expires.
+func (store *HistoryStore) Prune(now time.Time) error {
+	store.mu.Lock()
+	cutoff := now.Add(-store.retention)
+	// Records are in the order they were added, so there's nothing to do unless the oldest one expired
+	if now.Sub(store.prunedAt) < historyPruneInterval ||
+		len(store.records) == 0 || !store.records[0].Time.Before(cutoff) {
+		store.mu.Unlock()
+		return nil
+	}
+	store.prunedAt = now
+	store.expire(cutoff)
+	if store.path == "" {
+		store.mu.Unlock()
+		return nil
+	}
+	kept := append([]HistoryRecord(nil), store.records...)
+	added := store.added
+	store.mu.Unlock()
+
+	// Writing a month of records takes a while, Add keeps appending to the old file in the meantime
+	temp := store.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeRecords(file, kept); err != nil {
+		file.Close()
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	// Whatever was added while the file was written goes at the end of the new one
+	missed := store.added - added
+	if missed > len(store.records) {
+		missed = len(store.records)
+	}
+	if err := writeRecords(file, store.records[len(store.records)-missed:]); err != nil {
+		file.Close()
+		return err
+	}
+	return store.replaceFile(file, temp)
+}
+
+// trim drops the oldest records over maxHistoryRecords.
+func (store *HistoryStore) trim() {
+	if over := len(store.records) - maxHistoryRecords; over > 0 {
+		store.records = store.records[over:]
+	}
+}
+
+// expire drops the records from before the cutoff.
+func (store *HistoryStore) expire(cutoff time.Time) {
+	kept := store.records[:0]
+	for _, record := range store.records {
+		if !record.Time.Before(cutoff) {
+			kept = append(kept, record)
+		}
+	}
+	store.records = kept
+}
+
+// rewrite keeps the records within the retention period and replaces the file with them.
+func (store *HistoryStore) rewrite(now time.Time) error {
+	store.expire(now.Add(-store.retention))
+	if store.path == "" {
+		return nil
+	}
+
+	temp := store.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeRecords(file, store.records); err != nil {
+		file.Close()
+		return err
+	}
+	return store.replaceFile(file, temp)
+}
+
+// replaceFile closes the freshly written temp file, moves it over the store's file and appends to it from now on.
+func (store *HistoryStore) replaceFile(file *os.File, temp string) error {
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if store.file != nil {
+		store.file.Close()
+	}
+	if err := os.Rename(temp, store.path); err != nil {
+		return err
+	}
+
+	var err error
+	store.file, err = os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+func writeRecords(file *os.File, records []HistoryRecord) error {
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for i := range records {
+		if err := encoder.Encode(&records[i]); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// Query returns the newest records matching the query, newest first.
+func (store *HistoryStore) Query(q HistoryQuery) []HistoryRecord {
+	if q.Limit <= 0 {
+		q.Limit = defaultHistoryQueryLimit
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	found := []HistoryRecord{}
+	for i := len(store.records) - 1; i >= 0 && len(found) < q.Limit; i-- {
+		if q.matches(&store.records[i]) {
+			found = append(found, store.records[i])
+		}
+	}
+	return found
+}
+
+func (store *HistoryStore) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.file == nil {
+		return nil
+	}
+	return store.file.Close()
+}
+
+// recordDecision records whether a user was told about an event.
+func recordDecision(event, project string, mergeRequest int, user, actor, decision, detail string) {
+	history.Add(HistoryRecord{
+		Event:        event,
+		Project:      project,
+		MergeRequest: mergeRequest,
+		User:         user,
+		Actor:        actor,
+		Decision:     decision,
+		Detail:       detail,
+	})
+}
+
+// recordNoteDecision records whether the user was told about a comment.
+func recordNoteDecision(event *webhook.NoteEvent, user *User, decision, detail string) {
+	mergeRequest := 0
+	if event.MergeRequest != nil {
+		mergeRequest = event.MergeRequest.IID
+	}
+	recordDecision(webhook.KindNote, projectPath(event.Project), mergeRequest,
+		user.GitlabUsername, username(event.User), decision, detail)
+}
+
+// recordPipelineDecision records whether the user was told about a failed pipeline.
+func recordPipelineDecision(event *webhook.PipelineEvent, user *User, decision string) {
+	mergeRequest := 0
+	if event.MergeRequest != nil {
+		mergeRequest = event.MergeRequest.IID
+	}
+	recordDecision(webhook.KindPipeline, projectPath(event.Project), mergeRequest,
+		user.GitlabUsername, username(event.User), decision, "")
+}
+
+// recordEventDecision records whether the user, or the channel for announcements, was told about a webhook event.
+func recordEventDecision(kind string, event interface{}, user, decision, detail string) {
+	project, mergeRequest, actor := eventSubject(event)
+	recordDecision(kind, project, mergeRequest, user, actor, decision, detail)
+}
+
+// sentOrFailed tells from the channel a Slack client returned whether the message went out.
+func sentOrFailed(channel string) string {
+	if channel == "" {
+		return DecisionFailed
+	}
+	return DecisionSent
+}
+
+// recordWebhook records an event that came in, before any handler decided what to do with it.
+func recordWebhook(kind string, event interface{}) {
+	project, mergeRequest, actor := eventSubject(event)
+	history.Add(HistoryRecord{
+		Event:        kind,
+		Project:      project,
+		MergeRequest: mergeRequest,
+		Actor:        actor,
+		Decision:     DecisionReceived,
+	})
+}
+
+// eventSubject returns the project and merge request, if any, an event is about and who set it off.
+func eventSubject(event interface{}) (project string, mergeRequest int, actor string) {
+	switch e := event.(type) {
+	case *webhook.NoteEvent:
+		project, actor = projectPath(e.Project), username(e.User)
+		if e.MergeRequest != nil {
+			mergeRequest = e.MergeRequest.IID
+		}
+	case *webhook.MergeRequestEvent:
+		project, actor = projectPath(e.Project), username(e.User)
+		if e.ObjectAttributes != nil {
+			mergeRequest = e.ObjectAttributes.IID
+		}
+	case *webhook.PipelineEvent:
+		project, actor = projectPath(e.Project), username(e.User)
+		if e.MergeRequest != nil {
+			mergeRequest = e.MergeRequest.IID
+		}
+	case *webhook.IssueEvent:
+		project, actor = projectPath(e.Project), username(e.User)
+	case *webhook.JobEvent:
+		project, actor = projectPath(e.Project), username(e.User)
+	case *webhook.DeploymentEvent:
+		project, actor = projectPath(e.Project), username(e.User)
+	case *webhook.WikiPageEvent:
+		project, actor = projectPath(e.Project), username(e.User)
+	case *webhook.PushEvent:
+		project, actor = projectPath(e.Project), e.UserUsername
+	}
+	return project, mergeRequest, actor
+}
+
+func projectPath(project *webhook.Project) string {
+	if project == nil {
+		return ""
+	}
+	return project.PathWithNamespace
+}
+
+func username(user *webhook.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.Username
+}
+
+// AdminHistoryHandler searches the history with the user, project, merge_request, since, until and limit parameters.
+// Times are RFC 3339, e.g. 2021-04-28T21:50:00Z.
+func AdminHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := HistoryQuery{
+		User:    params.Get("user"),
+		Project: params.Get("project"),
+	}
+
+	var err error
+	if value := params.Get("merge_request"); value != "" {
+		if q.MergeRequest, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, "merge_request must be a number")
+			return
+		}
+	}
+	if value := params.Get("limit"); value != "" {
+		if q.Limit, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, "limit must be a number")
+			return
+		}
+	}
+	if value := params.Get("since"); value != "" {
+		if q.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+	}
+	if value := params.Get("until"); value != "" {
+		if q.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, "until must be an RFC 3339 time")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, history.Query(q))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreKeepsRecordsWithinTheRetentionPeriod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "history.jsonl")
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	recent := time.Now().Add(-1 * time.Hour).UTC().Format(time.RFC3339)
+	existing := `{"time": "` + old + `", "event": "note", "decision": "sent", "user": "smeriwether1"}` + "\n" +
+		`{"time": "` + recent + `", "event": "note", "decision": "sent", "user": "smeriwether2"}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(existing), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenHistoryStore(path, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(HistoryRecord{Event: "pipeline", Decision: DecisionSuppressedInactive, User: "smeriwether1"})
+	store.Close()
+
+	reopened, err := OpenHistoryStore(path, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	records := reopened.Query(HistoryQuery{})
+	if len(records) != 2 {
+		t.Fatalf("kept wrong number of records: got %d want 2: %+v", len(records), records)
+	}
+	if records[0].Event != "pipeline" || records[1].User != "smeriwether2" {
+		t.Errorf("kept wrong records, newest first: got %+v", records)
+	}
+}
+
+func TestHistoryStorePrune(t *testing.T) {
+	store := NewHistoryStore(30 * time.Minute)
+	now := time.Now()
+	store.Add(HistoryRecord{Time: now.Add(-2 * time.Hour), Event: "note", Decision: DecisionSent})
+	store.Add(HistoryRecord{Time: now, Event: "note", Decision: DecisionSent})
+
+	if err := store.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	if records := store.Query(HistoryQuery{}); len(records) != 1 {
+		t.Errorf("kept wrong number of records: got %d want 1", len(records))
+	}
+
+	// Expired records wait for the next hourly prune
+	if err := store.Prune(now.Add(45 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if records := store.Query(HistoryQuery{}); len(records) != 1 {
+		t.Errorf("pruned again within the hour: got %d records want 1", len(records))
+	}
+	if err := store.Prune(now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if records := store.Query(HistoryQuery{}); len(records) != 0 {
+		t.Errorf("kept wrong number of records: got %d want 0", len(records))
+	}
+}
+
+func TestHistoryStorePruneRewritesTheFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+
+	store, err := OpenHistoryStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	now := time.Now()
+	store.Add(HistoryRecord{Time: now.Add(-2 * time.Hour), Event: "note", Decision: DecisionSent, User: "smeriwether1"})
+	store.Add(HistoryRecord{Time: now, Event: "note", Decision: DecisionSent, User: "smeriwether2"})
+
+	if err := store.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	store.Add(HistoryRecord{Time: now, Event: "pipeline", Decision: DecisionSent, User: "smeriwether1"})
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "smeriwether2") || !strings.Contains(lines[1], "pipeline") {
+		t.Errorf("file has wrong records: %q", contents)
+	}
+}
+
+func TestHistoryStoreKeepsAtMostMaxHistoryRecords(t *testing.T) {
+	defer func(previous int) { maxHistoryRecords = previous }(maxHistoryRecords)
+	maxHistoryRecords = 2
+	store := NewHistoryStore(time.Hour)
+	for _, user := range []string{"smeriwether1", "smeriwether2", "smeriwether3"} {
+		store.Add(HistoryRecord{Event: "note", Decision: DecisionSent, User: user})
+	}
+
+	records := store.Query(HistoryQuery{})
+	if len(records) != 2 || records[0].User != "smeriwether3" || records[1].User != "smeriwether2" {
+		t.Errorf("kept wrong records, newest first: got %+v", records)
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	store := NewHistoryStore(24 * time.Hour)
+	now := time.Now()
+	store.Add(HistoryRecord{Time: now.Add(-3 * time.Hour), Event: "note", Project: "gitlab-org/gitlab-test", MergeRequest: 1, User: "smeriwether2", Actor: "smeriwether1", Decision: DecisionSent})
+	store.Add(HistoryRecord{Time: now.Add(-2 * time.Hour), Event: "note", Project: "gitlab-org/gitlab-test", MergeRequest: 2, User: "smeriwether1", Decision: DecisionSuppressedInactive})
+	store.Add(HistoryRecord{Time: now.Add(-1 * time.Hour), Event: "pipeline", Project: "mike/diaspora", User: "smeriwether2", Decision: DecisionSent})
+
+	tests := []struct {
+		name  string
+		query HistoryQuery
+		count int
+	}{
+		{"everything", HistoryQuery{}, 3},
+		{"user or actor", HistoryQuery{User: "smeriwether1"}, 2},
+		{"project", HistoryQuery{Project: "gitlab-org/gitlab-test"}, 2},
+		{"merge request", HistoryQuery{Project: "gitlab-org/gitlab-test", MergeRequest: 2}, 1},
+		{"since", HistoryQuery{Since: now.Add(-150 * time.Minute)}, 2},
+		{"until", HistoryQuery{Until: now.Add(-150 * time.Minute)}, 1},
+		{"limit", HistoryQuery{Limit: 1}, 1},
+	}
+
+	for _, test := range tests {
+		if records := store.Query(test.query); len(records) != test.count {
+			t.Errorf("%s: found wrong number of records: got %d want %d", test.name, len(records), test.count)
+		}
+	}
+}
+
+func TestCommentDecisionsAreRecorded(t *testing.T) {
+	defer func(previous *HistoryStore) { history = previous }(history)
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	history = NewHistoryStore(time.Hour)
+	activeUsers = &[]User{{GitlabUsername: "smeriwether1"}}
+	slackClient = &slackClientStub{}
+
+	serveEvent(t, "Note Hook", MergeRequestCommentRequest())
+
+	records := history.Query(HistoryQuery{Project: "gitlab-org/gitlab-test", MergeRequest: 1})
+	if len(records) != 2 {
+		t.Fatalf("recorded wrong number of records: got %d want 2: %+v", len(records), records)
+	}
+	if records[1].Decision != DecisionReceived || records[1].Actor != "root" {
+		t.Errorf("webhook was recorded wrong: got %+v", records[1])
+	}
+	if records[0].Decision != DecisionSuppressedInactive || records[0].User != "smeriwether2" {
+		t.Errorf("decision was recorded wrong: got %+v", records[0])
+	}
+}
+
+func TestAdminHistoryHandler(t *testing.T) {
+	defer func(previous *HistoryStore) { history = previous }(history)
+	history = NewHistoryStore(time.Hour)
+	history.Add(HistoryRecord{Event: "note", User: "smeriwether2", Decision: DecisionSent})
+	history.Add(HistoryRecord{Event: "note", User: "smeriwether1", Decision: DecisionSent})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(AdminHistoryHandler).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/history?user=smeriwether2", nil))
+
+	var records []HistoryRecord
+	if err := json.Unmarshal(rr.Body.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(records) != 1 || records[0].User != "smeriwether2" {
+		t.Errorf("handler returned wrong records: got %d %+v", rr.Code, records)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(AdminHistoryHandler).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/history?since=yesterday", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "since") {
+		t.Errorf("handler returned wrong response: got %d %s", rr.Code, rr.Body.String())
+	}
+}
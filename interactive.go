@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+const (
+	slackActionCallbackID = "gitlab_action"
+
+	retryPipelineActionName       = "retry_pipeline"
+	approveMergeRequestActionName = "approve_merge_request"
+	resolveDiscussionActionName   = "resolve_discussion"
+
+	// Slack recommends rejecting anything signed more than five minutes ago to prevent replays
+	slackSignatureMaxAge = 5 * time.Minute
+)
+
+// slackResponseClient posts results back to the response URL of a clicked button, Slack gives up on those
+// after a few seconds anyway.
+var slackResponseClient = &http.Client{Timeout: 10 * time.Second}
+
+// interactive is true when Slack can reach us with button clicks, there's no point showing buttons otherwise.
+func interactive() bool {
+	return slackSigningSecret != ""
+}
+
+// canApprove reports whether the user reviews or is assigned the merge request, authors can't approve their own.
+func canApprove(user *User, mr *webhook.MergeRequest) bool {
+	if user.GitlabID == 0 || user.GitlabID == mr.AuthorID {
+		return false
+	}
+	approvers := append(append([]int{}, mr.ReviewerIDs...), mr.AssigneeIDs...)
+	// Older Gitlab versions only have a single assignee
+	if mr.AssigneeID != nil {
+		approvers = append(approvers, *mr.AssigneeID)
+	}
+	for _, id := range approvers {
+		if id == user.GitlabID {
+			return true
+		}
+	}
+	return false
+}
+
+func retryPipelineAction(projectID, pipelineID int) MessageAction {
+	return MessageAction{
+		Name:  retryPipelineActionName,
+		Text:  "Retry failed jobs",
+		Value: fmt.Sprintf("%d:%d", projectID, pipelineID),
+		Style: "primary",
+	}
+}
+
+func approveMergeRequestAction(projectID, iid int) MessageAction {
+	return MessageAction{
+		Name:  approveMergeRequestActionName,
+		Text:  "Approve",
+		Value: fmt.Sprintf("%d:%d", projectID, iid),
+		Style: "primary",
+	}
+}
+
+func resolveDiscussionAction(projectID, iid int, discussionID string) MessageAction {
+	return MessageAction{
+		Name:  resolveDiscussionActionName,
+		Text:  "Mark resolved",
+		Value: fmt.Sprintf("%d:%d:%s", projectID, iid, discussionID),
+	}
+}
+
+// SlackActionHandler receives button clicks from Slack's interactivity request URL.
+// The click is acknowledged right away, the Gitlab call happens afterwards and the result replaces the buttons.
+func SlackActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Println("Error closing body:", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !validSlackSignature(r.Header, body, time.Now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var callback slack.AttachmentActionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte(fmt.Sprintf("JSON decoding error: %v", err))); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	if callback.CallbackID != slackActionCallbackID || len(callback.Actions) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user := findUserBySlackID(callback.User.ID)
+	if user == nil {
+		log.Printf("Slack user %s clicked %s but isn't mapped to a Gitlab user\n", callback.User.ID, callback.Actions[0].Name)
+		w.Header().Set("Content-Type", "application/json")
+		response := map[string]interface{}{
+			"response_type":    "ephemeral",
+			"replace_original": false,
+			"text":             "I couldn't find your Gitlab account, make sure your Slack and Gitlab emails match.",
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	go performSlackAction(user, callback)
+}
+
+func performSlackAction(user *User, callback slack.AttachmentActionCallback) {
+	action := callback.Actions[0]
+	log.Printf("%s clicked %s (%s)\n", user.GitlabUsername, action.Name, action.Value)
+
+	result, err := runSlackAction(user, action)
+	if err != nil {
+		log.Println(err)
+		result = fmt.Sprintf(":warning: %v", err)
+	}
+
+	if err := respondToSlackAction(callback, result); err != nil {
+		log.Println("Error updating message:", err)
+	}
+}
+
+func runSlackAction(user *User, action slack.AttachmentAction) (string, error) {
+	args := strings.SplitN(action.Value, ":", 3)
+
+	switch action.Name {
+	case retryPipelineActionName:
+		ids, err := parseActionIDs(args, 2)
+		if err != nil {
+			return "", err
+		}
+		if err := gitlabClient.RetryPipeline(ids[0], ids[1], user.GitlabID); err != nil {
+			return "", fmt.Errorf("Couldn't retry the pipeline: %v", err)
+		}
+		return fmt.Sprintf(":repeat: %s retried the failed jobs", user.GitlabUsername), nil
+
+	case approveMergeRequestActionName:
+		ids, err := parseActionIDs(args, 2)
+		if err != nil {
+			return "", err
+		}
+		if err := gitlabClient.ApproveMergeRequest(ids[0], ids[1], user.GitlabID); err != nil {
+			return "", fmt.Errorf("Couldn't approve the merge request: %v", err)
+		}
+		return fmt.Sprintf(":white_check_mark: %s approved the merge request", user.GitlabUsername), nil
+
+	case resolveDiscussionActionName:
+		if len(args) != 3 || args[2] == "" {
+			return "", fmt.Errorf("Invalid action value %q", action.Value)
+		}
+		ids, err := parseActionIDs(args[:2], 2)
+		if err != nil {
+			return "", err
+		}
+		if err := gitlabClient.ResolveDiscussion(ids[0], ids[1], args[2], user.GitlabID); err != nil {
+			return "", fmt.Errorf("Couldn't resolve the thread: %v", err)
+		}
+		return fmt.Sprintf(":heavy_check_mark: %s resolved the thread", user.GitlabUsername), nil
+	}
+
+	return "", fmt.Errorf("Unknown action %q", action.Name)
+}
+
+func parseActionIDs(args []string, count int) ([]int, error) {
+	if len(args) < count {
+		return nil, fmt.Errorf("Invalid action value %q", strings.Join(args, ":"))
+	}
+
+	ids := make([]int, count)
+	for i := 0; i < count; i++ {
+		id, err := strconv.Atoi(args[i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid action value %q", strings.Join(args, ":"))
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// respondToSlackAction replaces the original message, dropping the clicked button and adding the result.
+func respondToSlackAction(callback slack.AttachmentActionCallback, result string) error {
+	clicked := callback.Actions[0].Name
+
+	var attachments []slack.Attachment
+	for _, attachment := range callback.OriginalMessage.Attachments {
+		var remaining []slack.AttachmentAction
+		for _, action := range attachment.Actions {
+			if action.Name != clicked {
+				remaining = append(remaining, action)
+			}
+		}
+		attachment.Actions = remaining
+		if attachment.Text == "" && len(attachment.Actions) == 0 {
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+	attachments = append(attachments, slack.Attachment{Text: result, Fallback: result})
+
+	body, err := json.Marshal(map[string]interface{}{
+		"replace_original": true,
+		"text":             callback.OriginalMessage.Text,
+		"attachments":      attachments,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := slackResponseClient.Post(callback.ResponseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// validSlackSignature checks the request was signed with our signing secret, see
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func validSlackSignature(header http.Header, body []byte, now time.Time) bool {
+	if slackSigningSecret == "" {
+		return false
+	}
+
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > slackSignatureMaxAge || age < -slackSignatureMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(slackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
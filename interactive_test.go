@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+func TestSlackActionHandlerApprovesMergeRequest(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+	gitlabStub := gitlabClientStub{}
+	gitlabClient = &gitlabStub
+
+	responses := make(chan map[string]interface{}, 1)
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+			t.Error(err)
+		}
+		responses <- response
+	}))
+	defer slackServer.Close()
+
+	req := signedSlackActionRequest(t, slackActionPayload("SLACKID2", "approve_merge_request", "10:1", slackServer.URL))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SlackActionHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	select {
+	case response := <-responses:
+		if response["replace_original"] != true {
+			t.Errorf("action response should replace the original message: %v", response)
+		}
+		body, _ := json.Marshal(response)
+		if !strings.Contains(string(body), "smeriwether2 approved the merge request") {
+			t.Errorf("action response has the wrong result: %s", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no response sent to the response_url")
+	}
+
+	if len(gitlabStub.calls) != 1 || gitlabStub.calls[0] != "approve 10!1 as 2" {
+		t.Errorf("gitlab client received wrong calls: got %v want %v", gitlabStub.calls, []string{"approve 10!1 as 2"})
+	}
+}
+
+func TestSlackActionHandlerRejectsBadSignature(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+	gitlabStub := gitlabClientStub{}
+	gitlabClient = &gitlabStub
+
+	req := signedSlackActionRequest(t, slackActionPayload("SLACKID2", "approve_merge_request", "10:1", "http://example.com"))
+	req.Header.Set("X-Slack-Signature", "v0=00000000")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SlackActionHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	if len(gitlabStub.calls) != 0 {
+		t.Errorf("gitlab client should not have been called: %v", gitlabStub.calls)
+	}
+}
+
+func TestValidSlackSignatureRejectsOldRequests(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+
+	req := signedSlackActionRequest(t, "payload=%7B%7D")
+	body, _ := ioutil.ReadAll(req.Body)
+
+	if !validSlackSignature(req.Header, body, time.Now()) {
+		t.Errorf("signature should be valid")
+	}
+	if validSlackSignature(req.Header, body, time.Now().Add(10*time.Minute)) {
+		t.Errorf("signature should be too old")
+	}
+}
+
+func TestPipelineWebhookHandlerAddsRetryButton(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = &[]User{
+		{GitlabUsername: "smeriwether1"},
+	}
+	// Older Gitlab versions don't send the project ID with pipeline events
+	payload := bytes.Replace(FailedPipelineRequest(), []byte(`"project": {`), []byte(`"project": {"id": 15,`), 1)
+	handler := http.HandlerFunc(PipelineWebhookHandler)
+	req, err := http.NewRequest("POST", "/pipeline", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if len(slackStub.receivedActions) != 1 || slackStub.receivedActions[0].Value != "15:1" {
+		t.Errorf("slack client received wrong actions: got %v want a %v button",
+			slackStub.receivedActions, retryPipelineActionName)
+	}
+}
+
+func TestCommentWebhookHandlerDoesNotOfferApproveToTheAuthor(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+	handler := http.HandlerFunc(CommentWebhookHandler)
+	req, err := http.NewRequest("POST", "/comments", bytes.NewBuffer(MergeRequestCommentRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if slackStub.receivedChannel != "SLACKID2" {
+		t.Errorf("slack client received wrong channel: got %v want %v", slackStub.receivedChannel, "SLACKID2")
+	}
+	for _, action := range slackStub.receivedActions {
+		if action.Name == approveMergeRequestActionName {
+			t.Errorf("the author of the merge request was offered to approve it: %v", slackStub.receivedActions)
+		}
+	}
+}
+
+func TestWebhookHandlerOffersApproveToReviewers(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Merge Request Hook", MergeRequestRequest("open", false, `{}`))
+
+	if len(slackStub.receivedActions) != 1 || slackStub.receivedActions[0].Name != approveMergeRequestActionName ||
+		slackStub.receivedActions[0].Value != "5:1" {
+		t.Errorf("slack client received wrong actions: got %v want a %v button",
+			slackStub.receivedActions, approveMergeRequestActionName)
+	}
+}
+
+func TestCanApprove(t *testing.T) {
+	assignee := 3
+	mr := &webhook.MergeRequest{AuthorID: 1, ReviewerIDs: []int{1, 2}, AssigneeID: &assignee}
+	for id, want := range map[int]bool{1: false, 2: true, 3: true, 4: false} {
+		if got := canApprove(&User{GitlabID: id}, mr); got != want {
+			t.Errorf("canApprove for user %d: got %v want %v", id, got, want)
+		}
+	}
+}
+
+func slackActionPayload(slackID, name, value, responseURL string) string {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"callback_id":  slackActionCallbackID,
+		"actions":      []map[string]string{{"name": name, "value": value, "type": "button"}},
+		"user":         map[string]string{"id": slackID},
+		"response_url": responseURL,
+		"original_message": map[string]interface{}{
+			"text": "smeriwether1 made a comment on your <http://example.com|Merge Request>",
+		},
+	})
+	return "payload=" + url.QueryEscape(string(payload))
+}
+
+func signedSlackActionRequest(t *testing.T, body string) *http.Request {
+	req, err := http.NewRequest("POST", "/slack/actions", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(slackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
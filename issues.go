@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+func validateIssue(event *webhook.IssueEvent) error {
+	if event.ObjectAttributes == nil || event.Project == nil {
+		return errors.New("Not valid or not an issue request")
+	}
+	return nil
+}
+
+func handleIssue(w http.ResponseWriter, event *webhook.IssueEvent) {
+	if err := validateIssue(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	activity := issueActivity(event)
+	if activity == "" {
+		writeIgnored(w, "Nothing worth telling anyone")
+		return
+	}
+
+	if users == nil {
+		writeError(w, http.StatusInternalServerError, "User discovery error")
+		return
+	}
+
+	actor := &User{}
+	if event.User != nil {
+		actor = orEmpty(findUserByID(event.User.ID))
+	}
+
+	project := event.Project.PathWithNamespace
+	var receivers []*User
+	for _, participant := range issueParticipants(event.ObjectAttributes) {
+		// Don't tell anyone about something they did themselves
+		if !activeUser(participant) || participant.Same(actor) {
+			decision := DecisionSuppressedSelf
+			if !activeUser(participant) {
+				decision = DecisionSuppressedInactive
+			}
+			recordDecision(webhook.KindIssue, project, 0, participant.GitlabUsername, actorName(event), decision, "")
+			continue
+		}
+		receivers = append(receivers, participant)
+	}
+
+	if len(receivers) == 0 {
+		log.Printf("Nobody to tell that %s %s issue #%d\n", actorName(event), activity, event.ObjectAttributes.IID)
+		writeIgnored(w, "Nobody to notify")
+		return
+	}
+
+	issue := event.ObjectAttributes
+	message := fmt.Sprintf(
+		"%s %s <%s|%s#%d> %s",
+		actorName(event), activity, issue.URL, event.Project.PathWithNamespace, issue.IID, issue.Title,
+	)
+
+	deliverEach(receivers, func(receiver *User) {
+		log.Printf("Telling %s: %s\n", receiver.GitlabUsername, message)
+		channel, _ := slackClient.PostMessage(receiver.SlackID, message, "")
+		recordDecision(webhook.KindIssue, project, 0, receiver.GitlabUsername, actorName(event), sentOrFailed(channel), activity)
+	})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// issueActivity describes what happened to an issue, it is empty for updates nobody needs to hear about.
+func issueActivity(event *webhook.IssueEvent) string {
+	switch event.ObjectAttributes.Action {
+	case "open":
+		return "opened"
+	case "close":
+		return "closed"
+	case "reopen":
+		return "reopened"
+	case "update":
+		if assignees := changedUsers(event.Changes["assignees"]); len(assignees) > 0 {
+			return fmt.Sprintf("assigned %s to", strings.Join(assignees, ", "))
+		}
+		if labels := changedLabels(event.Changes["labels"]); len(labels) > 0 {
+			return fmt.Sprintf("added %s to", strings.Join(labels, ", "))
+		}
+	}
+	return ""
+}
+
+// changedUsers returns the usernames that were added by the change.
+func changedUsers(change webhook.Change) []string {
+	var added []string
+	for _, user := range addedUsers(change) {
+		added = append(added, user.Username)
+	}
+	return added
+}
+
+// addedUsers returns the users in the current value of the change that weren't in the previous one.
+func addedUsers(change webhook.Change) []webhook.User {
+	var previous, current []webhook.User
+	if err := json.Unmarshal(change.Current, &current); err != nil {
+		return nil
+	}
+	// There's no previous value when there were no users
+	_ = json.Unmarshal(change.Previous, &previous)
+
+	var added []webhook.User
+	for _, user := range current {
+		found := false
+		for _, p := range previous {
+			found = found || p.ID == user.ID
+		}
+		if !found {
+			added = append(added, user)
+		}
+	}
+	return added
+}
+
+// changedLabels returns the labels that were added by the change, as ~label references.
+func changedLabels(change webhook.Change) []string {
+	var previous, current []webhook.Label
+	if err := json.Unmarshal(change.Current, &current); err != nil {
+		return nil
+	}
+	// There's no previous value when the issue had none
+	_ = json.Unmarshal(change.Previous, &previous)
+
+	var added []string
+	for _, label := range current {
+		found := false
+		for _, p := range previous {
+			found = found || p.ID == label.ID
+		}
+		if !found {
+			added = append(added, "~"+label.Title)
+		}
+	}
+	return added
+}
+
+func actorName(event *webhook.IssueEvent) string {
+	if event.User == nil {
+		return "Someone"
+	}
+	return event.User.Username
+}
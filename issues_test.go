@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandlerWithAnOpenedIssue(t *testing.T) {
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	rr := serveEvent(t, "Issue Hook", IssueRequest("open", `{}`))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	// smeriwether1 opened the issue, so only the assignee hears about it
+	if !reflect.DeepEqual(slackStub.receivedChannels, []string{"SLACKID2"}) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, []string{"SLACKID2"})
+	}
+
+	expected := "smeriwether1 opened <http://example.com/gitlab-org/gitlab-test/issues/23|gitlab-org/gitlab-test#23> New API: create/update/delete file"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+}
+
+func TestWebhookHandlerWithAnAssignedIssue(t *testing.T) {
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	changes := `{"assignees": {"previous": [], "current": [{"id": 2, "username": "smeriwether2"}]}}`
+	serveEvent(t, "Issue Hook", IssueRequest("update", changes))
+
+	if !strings.Contains(slackStub.receivedMessage, "smeriwether1 assigned smeriwether2 to <") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "smeriwether1 assigned smeriwether2 to <")
+	}
+}
+
+func TestWebhookHandlerWithALabeledIssue(t *testing.T) {
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	changes := `{"labels": {"previous": [{"id": 1, "title": "API"}], "current": [{"id": 1, "title": "API"}, {"id": 2, "title": "bug"}]}}`
+	serveEvent(t, "Issue Hook", IssueRequest("update", changes))
+
+	if !strings.Contains(slackStub.receivedMessage, "smeriwether1 added ~bug to <") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "smeriwether1 added ~bug to <")
+	}
+}
+
+func TestWebhookHandlerIgnoresOtherIssueUpdates(t *testing.T) {
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	changes := `{"title": {"previous": "New API", "current": "New API: create/update/delete file"}}`
+	rr := serveEvent(t, "Issue Hook", IssueRequest("update", changes))
+
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusAccepted)
+	}
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestCommentWebhookHandlerFromIssueComment(t *testing.T) {
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	req, err := http.NewRequest("POST", "/comments", bytes.NewBuffer(IssueCommentRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(CommentWebhookHandler).ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	// smeriwether2 wrote the issue and is assigned to it, smeriwether1 wrote the comment
+	if !reflect.DeepEqual(slackStub.receivedChannels, []string{"SLACKID2"}) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, []string{"SLACKID2"})
+	}
+
+	expected := "smeriwether1 made a comment on your <http://example.com/gitlab-org/gitlab-test/issues/17#note_1241|Issue #17>"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+
+	target, ok := commentThreads.Lookup("DSLACKID2", "1500000000.000100")
+	if want := (NoteTarget{ProjectID: 5, IssueIID: 17, DiscussionID: "7f3a1e4c"}); !ok || target != want {
+		t.Errorf("comment thread remembered wrong target: got %v want %v", target, want)
+	}
+}
+
+func IssueRequest(action, changes string) []byte {
+	return []byte(fmt.Sprintf(
+		`
+	{
+		"object_kind": "issue",
+		"event_type": "issue",
+		"user": {
+			"id": 1,
+			"name": "Stephen 1 Meriwether",
+			"username": "smeriwether1",
+			"email": "stephen1@molecule.io"
+		},
+		"project": {
+			"id": 5,
+			"name": "Gitlab Test",
+			"web_url": "http://example.com/gitlab-org/gitlab-test",
+			"path_with_namespace": "gitlab-org/gitlab-test",
+			"default_branch": "master"
+		},
+		"object_attributes": {
+			"id": 301,
+			"iid": 23,
+			"title": "New API: create/update/delete file",
+			"description": "Create new API for manipulations with repository",
+			"state": "opened",
+			"action": %q,
+			"author_id": 1,
+			"assignee_ids": [2],
+			"project_id": 5,
+			"created_at": "2013-12-03T17:15:43Z",
+			"updated_at": "2013-12-03T17:15:43Z",
+			"url": "http://example.com/gitlab-org/gitlab-test/issues/23"
+		},
+		"assignees": [
+			{
+				"id": 2,
+				"name": "Stephen 2 Meriwether",
+				"username": "smeriwether2"
+			}
+		],
+		"labels": [],
+		"changes": %s
+	}
+	`,
+		action, changes,
+	))
+}
+
+func IssueCommentRequest() []byte {
+	return []byte(
+		`
+	{
+		"object_kind": "note",
+		"event_type": "note",
+		"user": {
+			"id": 1,
+			"name": "Stephen 1 Meriwether",
+			"username": "smeriwether1"
+		},
+		"project_id": 5,
+		"project": {
+			"id": 5,
+			"name": "Gitlab Test",
+			"web_url": "http://example.com/gitlab-org/gitlab-test",
+			"path_with_namespace": "gitlab-org/gitlab-test",
+			"default_branch": "master"
+		},
+		"object_attributes": {
+			"id": 1241,
+			"note": "Hello world",
+			"noteable_type": "Issue",
+			"author_id": 1,
+			"created_at": "2015-05-17 17:06:40 UTC",
+			"updated_at": "2015-05-17 17:06:40 UTC",
+			"project_id": 5,
+			"commit_id": "",
+			"noteable_id": 92,
+			"system": false,
+			"discussion_id": "7f3a1e4c",
+			"url": "http://example.com/gitlab-org/gitlab-test/issues/17#note_1241"
+		},
+		"issue": {
+			"id": 92,
+			"iid": 17,
+			"title": "test",
+			"state": "opened",
+			"author_id": 2,
+			"assignee_ids": [2, 1],
+			"project_id": 5,
+			"url": "http://example.com/gitlab-org/gitlab-test/issues/17"
+		}
+	}
+	`,
+	)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+func validateJob(event *webhook.JobEvent) error {
+	if event.BuildID == 0 {
+		return errors.New("Not valid or not a job request")
+	}
+	return nil
+}
+
+func handleJob(w http.ResponseWriter, event *webhook.JobEvent) {
+	if err := validateJob(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := jobMessage(event, config.LongJob())
+	if message == "" {
+		writeIgnored(w, "Nothing worth telling anyone")
+		return
+	}
+
+	user := jobUser(event)
+	if user == nil {
+		log.Printf("Not reporting job %d because its user isn't mapped to a Slack user\n", event.BuildID)
+		recordEventDecision(webhook.KindJob, event, username(event.User), DecisionSuppressedUnknown,
+			"no Slack account for the job user")
+		writeIgnored(w, "Job user has no Slack account")
+		return
+	}
+
+	// Don't send message if the receiver is not an active user
+	if !activeUser(user) {
+		log.Printf("Not reporting job %d because %s is not active\n", event.BuildID, user.GitlabUsername)
+		recordEventDecision(webhook.KindJob, event, user.GitlabUsername, DecisionSuppressedInactive, "")
+		writeIgnored(w, "Job user is not active")
+		return
+	}
+
+	log.Printf("Reporting job %d to %s\n", event.BuildID, user.GitlabUsername)
+	deliver(user.SlackID, func() {
+		channel, _ := slackClient.PostMessage(user.SlackID, message, "")
+		recordEventDecision(webhook.KindJob, event, user.GitlabUsername, sentOrFailed(channel), "")
+	})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// jobMessage describes the job if it's waiting to be played, failed again after being retried or
+// finished after running for longer than longJob. It is empty for any other job.
+func jobMessage(event *webhook.JobEvent, longJob time.Duration) string {
+	job := fmt.Sprintf("<%s|%s>", jobURL(event), event.BuildName)
+	where := fmt.Sprintf("in %s (%s)", jobProjectName(event), event.Ref)
+
+	switch event.BuildStatus {
+	case webhook.StatusManual:
+		return fmt.Sprintf(":arrow_forward: %s %s is waiting for you to play it", job, where)
+
+	case "failed", "success", "canceled":
+		if event.BuildStatus == "failed" && event.RetriesCount > 0 {
+			return fmt.Sprintf(":x: %s failed again %s after %s", job, where, retries(event.RetriesCount))
+		}
+
+		if event.BuildDuration == nil {
+			return ""
+		}
+		duration := time.Duration(*event.BuildDuration * float64(time.Second))
+		if duration < longJob {
+			return ""
+		}
+		return fmt.Sprintf(
+			":stopwatch: %s %s finished with status %s after %s",
+			job, where, event.BuildStatus, duration.Round(time.Second),
+		)
+	}
+
+	return ""
+}
+
+// jobUser is whoever started the job, which is who played it for manual jobs.
+func jobUser(event *webhook.JobEvent) *User {
+	if event.User == nil {
+		return nil
+	}
+	if user := findUserByID(event.User.ID); user != nil {
+		return user
+	}
+	if event.User.Email != "" {
+		return findUserByEmail(event.User.Email)
+	}
+	return nil
+}
+
+// jobURL links to the job, older Gitlab versions only send the repository homepage.
+func jobURL(event *webhook.JobEvent) string {
+	webURL := ""
+	if event.Project != nil {
+		webURL = event.Project.WebURL
+	} else if event.Repository != nil {
+		webURL = event.Repository.Homepage
+	}
+	return fmt.Sprintf("%s/-/jobs/%d", webURL, event.BuildID)
+}
+
+func jobProjectName(event *webhook.JobEvent) string {
+	if event.Project != nil && event.Project.PathWithNamespace != "" {
+		return event.Project.PathWithNamespace
+	}
+	return event.ProjectName
+}
+
+func retries(count int) string {
+	if count == 1 {
+		return "1 retry"
+	}
+	return fmt.Sprintf("%d retries", count)
+}
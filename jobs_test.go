@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+func TestWebhookHandlerWithAManualJob(t *testing.T) {
+	config = &Config{}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	rr := serveEvent(t, "Job Hook", JobRequest("manual", 0, 0))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	if slackStub.receivedChannel != "SLACKID2" {
+		t.Errorf("slack client received wrong channel: got %v want %v",
+			slackStub.receivedChannel, "SLACKID2")
+	}
+
+	expected := "<http://192.168.64.1:3005/gitlab-org/gitlab-test/-/jobs/1977|deploy> in gitlab-org/gitlab-test (master) is waiting for you to play it"
+	if !strings.Contains(slackStub.receivedMessage, expected) {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, expected)
+	}
+}
+
+func TestWebhookHandlerWithALongRunningJob(t *testing.T) {
+	config = &Config{LongJobMinutes: 5}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Job Hook", JobRequest("success", 421.7, 0))
+
+	if !strings.Contains(slackStub.receivedMessage, "finished with status success after 7m2s") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "finished with status success after 7m2s")
+	}
+}
+
+func TestWebhookHandlerWithAQuickJob(t *testing.T) {
+	config = &Config{LongJobMinutes: 5}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Job Hook", JobRequest("failed", 60, 0))
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestWebhookHandlerWithARetriedJobFailingAgain(t *testing.T) {
+	config = &Config{}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Job Hook", JobRequest("failed", 60, 2))
+
+	if !strings.Contains(slackStub.receivedMessage, "failed again in gitlab-org/gitlab-test (master) after 2 retries") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "failed again in gitlab-org/gitlab-test (master) after 2 retries")
+	}
+}
+
+func TestWebhookHandlerWithAJobFromAnInactiveUser(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = &[]User{
+		{GitlabUsername: "smeriwether1"},
+	}
+	config = &Config{}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Job Hook", JobRequest("manual", 0, 0))
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestJobDecisionsAreRecorded(t *testing.T) {
+	defer func(previous *HistoryStore) { history = previous }(history)
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	history = NewHistoryStore(time.Hour)
+	activeUsers = users
+	config = &Config{}
+	slackClient = &slackClientStub{}
+
+	serveEvent(t, "Job Hook", JobRequest("manual", 0, 0))
+	activeUsers = &[]User{{GitlabUsername: "smeriwether1"}}
+	serveEvent(t, "Job Hook", JobRequest("manual", 0, 0))
+
+	var decisions []string
+	for _, record := range history.Query(HistoryQuery{User: "smeriwether2"}) {
+		if record.Decision == DecisionReceived {
+			continue
+		}
+		if record.Event != webhook.KindJob || record.User != "smeriwether2" {
+			t.Errorf("decision was recorded wrong: got %+v", record)
+		}
+		decisions = append(decisions, record.Decision)
+	}
+	// Newest first
+	expected := []string{DecisionSuppressedInactive, DecisionSent}
+	if !reflect.DeepEqual(decisions, expected) {
+		t.Errorf("recorded wrong decisions: got %v want %v", decisions, expected)
+	}
+}
+
+func JobRequest(status string, duration float64, retries int) []byte {
+	return []byte(fmt.Sprintf(
+		`
+	{
+		"object_kind": "build",
+		"ref": "master",
+		"tag": false,
+		"before_sha": "2293ada6b400935a1378653304eaf6221e0fdb8f",
+		"sha": "2293ada6b400935a1378653304eaf6221e0fdb8f",
+		"retries_count": %d,
+		"build_id": 1977,
+		"build_name": "deploy",
+		"build_stage": "deploy",
+		"build_status": %q,
+		"build_created_at": "2021-02-23T02:41:37.886Z",
+		"build_started_at": "2021-02-23T02:41:40.012Z",
+		"build_finished_at": "2021-02-23T02:46:25.512Z",
+		"build_duration": %v,
+		"build_allow_failure": false,
+		"pipeline_id": 2366,
+		"project_id": 380,
+		"project_name": "Gitlab Org / Gitlab Test",
+		"user": {
+			"id": 2,
+			"name": "Stephen 2 Meriwether",
+			"username": "smeriwether2",
+			"email": "stephen2@molecule.io"
+		},
+		"commit": {
+			"id": 2366,
+			"sha": "2293ada6b400935a1378653304eaf6221e0fdb8f",
+			"message": "test\n",
+			"author_name": "Stephen 2 Meriwether",
+			"author_email": "stephen2@molecule.io",
+			"status": "running"
+		},
+		"project": {
+			"id": 380,
+			"name": "Gitlab Test",
+			"web_url": "http://192.168.64.1:3005/gitlab-org/gitlab-test",
+			"path_with_namespace": "gitlab-org/gitlab-test",
+			"default_branch": "master"
+		},
+		"environment": null
+	}
+	`,
+		retries, status, duration,
+	))
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -15,57 +18,110 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/nlopes/slack"
 	"github.com/rs/cors"
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
 	gitlab "github.com/xanzy/go-gitlab"
 )
 
 var (
-	secretToken  string
-	botName      string
-	users        *[]User
-	activeUsers  *[]User
-	slackClient  SlackReadWriter
-	gitlabClient GitlabReader
+	slackSigningSecret string
+	botName            string
+	users              *[]User
+	activeUsers        *[]User
+	slackClient        SlackReadWriter
+	gitlabClient       GitlabReadWriter
+	config             *Config
 )
 
 func main() {
+	os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// serve listens for Gitlab events until it is interrupted, it's what the bot does without a subcommand.
+func serve(flags *flag.FlagSet, args []string, out io.Writer) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
 	log.Println("Listening for Gitlab events")
 	defer log.Println("Stopping...")
 
-	secretToken = os.Getenv("SECRET_TOKEN")
-	botName = os.Getenv("BOT_NAME")
-	slackToken := os.Getenv("SLACK_TOKEN")
-	gitlabToken := os.Getenv("GITLAB_TOKEN")
-	activeUsernames := os.Getenv("ACTIVE_USERS")
-	sslKey := os.Getenv("SSL_KEY_PATH")
-	sslCert := os.Getenv("SSL_CERT_PATH")
+	listenAddr := os.Getenv("LISTEN_ADDR")
+	if listenAddr == "" {
+		listenAddr = defaultListenAddr
+	}
+	tlsSettings := tlsSettingsFromEnv()
+	dryRun := os.Getenv("DRY_RUN") == "true"
+	capturePath := os.Getenv("CAPTURE_PATH")
+	captureMaxMB := os.Getenv("CAPTURE_MAX_MB")
+	historyPath := os.Getenv("HISTORY_PATH")
+	allowedCIDRs := os.Getenv("WEBHOOK_ALLOWED_CIDRS")
+	trustedProxies := os.Getenv("TRUSTED_PROXIES")
+	corsOrigins := os.Getenv("ADMIN_CORS_ORIGINS")
 
-	if slackClient == nil {
-		if slackToken == "" {
-			panic("SLACK_TOKEN must not be empty")
+	if err := setup(); err != nil {
+		return err
+	}
+	if dryRun {
+		log.Println("Dry run, messages are logged instead of sent")
+		slackClient = NewDryRunSlackClient(logWriter{}, slackClient)
+	}
+
+	if err := setupActiveUsers(); err != nil {
+		return err
+	}
+
+	if capturePath != "" {
+		maxBytes, err := captureMaxBytes(captureMaxMB)
+		if err != nil {
+			return err
 		}
-		slackClient = NewSlackClient(slackToken)
+
+		recorder, err := NewDeliveryRecorder(capturePath, maxBytes)
+		if err != nil {
+			return err
+		}
+		defer recorder.Close()
+		deliveryRecorder = recorder
+		log.Printf("Capturing Gitlab deliveries to %s\n", capturePath)
 	}
 
-	if gitlabClient == nil {
-		if gitlabToken == "" {
-			panic("GITLAB_TOKEN must not be empty")
+	if allowedCIDRs != "" {
+		allowlist, err := NewSourceAllowlist(allowedCIDRs, trustedProxies)
+		if err != nil {
+			return err
 		}
-		gitlabClient = NewGitlabClient(gitlabToken)
+		sourceAllowlist = allowlist
+		log.Printf("Only accepting webhooks from %s\n", allowedCIDRs)
 	}
 
-	if activeUsers == nil {
-		usernames := strings.Split(activeUsernames, ",")
-		var internalActiveUsernames []User
-		for _, username := range usernames {
-			if username == "" {
-				continue
-			}
-			internalActiveUsernames = append(internalActiveUsernames, User{GitlabUsername: username})
+	for _, origin := range strings.Split(corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			adminCORSOrigins = append(adminCORSOrigins, origin)
 		}
-		activeUsers = &internalActiveUsernames
-		if activeUsers == nil || len(*activeUsers) == 0 {
-			panic("ACTIVE_USERS must not be empty")
+	}
+
+	history = NewHistoryStore(config.HistoryRetention())
+	if historyPath != "" {
+		store, err := OpenHistoryStore(historyPath, config.HistoryRetention())
+		if err != nil {
+			return err
 		}
+		defer store.Close()
+		history = store
+		log.Printf("Keeping history in %s\n", historyPath)
+	}
+
+	if config.Workers > 0 {
+		stopWorkers := startWorkers(config.Workers)
+		// Runs once the server is closed, anything already acknowledged is still handled
+		defer stopWorkers()
+		log.Printf("Handling webhooks with %d workers\n", config.Workers)
+	} else {
+		// Comments still waiting for more are sent before the bot stops
+		defer pendingComments.FlushAll()
 	}
 
 	// Every so often we should double check the gitlab & slack users
@@ -78,35 +134,31 @@ func main() {
 		}
 	}()
 
-	useSSL := true
-	if _, err := os.Stat(sslKey); os.IsNotExist(err) {
-		log.Println("Unable to find ssl key")
-		useSSL = false
-	}
-	if _, err := os.Stat(sslCert); os.IsNotExist(err) {
-		log.Println("Unable to find ssl certificate")
-		useSSL = false
-	}
-
-	r := mux.NewRouter()
-	r.HandleFunc("/comments", CommentWebhookHandler).Methods("POST")
-	r.HandleFunc("/pipeline", PipelineWebhookHandler).Methods("POST")
-	r.HandleFunc("/healthz", HealthzHandler).Methods("GET")
+	// Digests and stale reminders go out at configured times of day so we check what is due every minute
+	scheduleTicker := time.NewTicker(time.Minute)
+	defer scheduleTicker.Stop()
+	go func() {
+		for now := range scheduleTicker.C {
+			sendDueDigests(now)
+			sendDueStaleReminders(now)
+			if err := history.Prune(now); err != nil {
+				log.Println("Error pruning history:", err)
+			}
+		}
+	}()
 
-	loggingHandler := handlers.LoggingHandler(os.Stderr, r)
-	authHandler := AuthHandler{loggingHandler}
-	errorHandler := ErrorHandler{authHandler}
+	tlsConfig, err := tlsSettings.Config()
+	if err != nil {
+		return err
+	}
+	requireClientCerts = tlsConfig != nil && tlsConfig.ClientCAs != nil
 
-	handler := http.NewServeMux()
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedHeaders: []string{"accept", "x-csrf-token"},
-	})
-	handler.Handle("/", c.Handler(errorHandler))
+	handler := newHandler()
 
 	tlsServer := &http.Server{
 		Handler:      handler,
-		Addr:         ":9090",
+		Addr:         listenAddr,
+		TLSConfig:    tlsConfig,
 		WriteTimeout: 30 * time.Second,
 		ReadTimeout:  30 * time.Second,
 		ErrorLog:     log.New(ioutil.Discard, "Debug: ", log.Ldate|log.Ltime),
@@ -118,72 +170,157 @@ func main() {
 	go func() {
 		<-sig
 		ticker.Stop()
+		scheduleTicker.Stop()
 		if err := tlsServer.Close(); err != nil {
 			log.Println("Error closing server", err)
 		}
-		log.Println("Exiting...")
-		os.Exit(1)
-
 	}()
 
-	log.Println("Listening for requests on :9090...")
-	if useSSL {
-		log.Fatal(tlsServer.ListenAndServeTLS(sslCert, sslKey))
+	log.Printf("Listening for requests on %s...\n", listenAddr)
+	if tlsConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate so it can be reloaded
+		err = tlsServer.ListenAndServeTLS("", "")
 	} else {
-		log.Fatal(tlsServer.ListenAndServe())
+		err = tlsServer.ListenAndServe()
 	}
+	if err == http.ErrServerClosed {
+		log.Println("Exiting...")
+		return nil
+	}
+	return err
 }
 
-func HealthzHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "ok")
-}
-
-func PipelineWebhookHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// setup reads the settings shared by every command that talks to Gitlab and Slack from the environment.
+func setup() error {
+	secretTokens = parseSecrets(os.Getenv("SECRET_TOKEN"))
+	botName = os.Getenv("BOT_NAME")
+	slackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	slackToken := os.Getenv("SLACK_TOKEN")
+	gitlabToken := os.Getenv("GITLAB_TOKEN")
 
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		if _, err := w.Write([]byte("Body must not be empty")); err != nil {
-			log.Println(err)
+	if config == nil {
+		cfg, err := loadConfig(os.Getenv("CONFIG_PATH"))
+		if err != nil {
+			return err
 		}
-		return
+		config = cfg
 	}
-	defer func() {
-		if err := r.Body.Close(); err != nil {
-			log.Println("Error closing body:", err)
+
+	if slackClient == nil {
+		if slackToken == "" {
+			return errors.New("SLACK_TOKEN must not be empty")
 		}
-	}()
+		slackClient = NewSlackClient(slackToken)
+	}
 
-	var root RootRequest
-	if err := json.NewDecoder(r.Body).Decode(&root); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println(err)
-		if _, err := w.Write([]byte(fmt.Sprintf("JSON decoding error: %v", err))); err != nil {
-			log.Println(err)
+	if gitlabClient == nil {
+		if gitlabToken == "" {
+			return errors.New("GITLAB_TOKEN must not be empty")
 		}
-		return
+		gitlabClient = NewGitlabClient(gitlabToken)
 	}
 
-	// This handler *should* only receive pipeline updates but we need to still reject everything else.
-	if !root.PipelineRequest() || !root.Valid() || root.Commit == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		if _, err := w.Write([]byte("Not valid or not a pipline request")); err != nil {
-			log.Println(err)
+	return nil
+}
+
+func setupActiveUsers() error {
+	if activeUsers != nil {
+		return nil
+	}
+
+	internalActiveUsernames, err := parseActiveUsers(os.Getenv("ACTIVE_USERS"))
+	if err != nil {
+		return err
+	}
+	activeUsers = &internalActiveUsernames
+	return nil
+}
+
+// parseActiveUsers splits the comma separated Gitlab usernames in ACTIVE_USERS.
+func parseActiveUsers(value string) ([]User, error) {
+	var internalActiveUsernames []User
+	for _, username := range strings.Split(value, ",") {
+		if username == "" {
+			continue
 		}
+		internalActiveUsernames = append(internalActiveUsernames, User{GitlabUsername: username})
+	}
+	if len(internalActiveUsernames) == 0 {
+		return nil, errors.New("ACTIVE_USERS must not be empty")
+	}
+	return internalActiveUsernames, nil
+}
+
+// newHandler builds the routes and the middleware every request goes through, replay uses it as well.
+func newHandler() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/webhook", WebhookHandler).Methods("POST")
+	r.HandleFunc("/comments", CommentWebhookHandler).Methods("POST")
+	r.HandleFunc("/pipeline", PipelineWebhookHandler).Methods("POST")
+	r.HandleFunc("/push", PushWebhookHandler).Methods("POST")
+	r.HandleFunc("/healthz", HealthzHandler).Methods("GET")
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	r.Handle("/admin/dry-run", AdminHandler{http.HandlerFunc(DryRunHandler)}).Methods("GET")
+	r.Handle("/admin/users", AdminHandler{http.HandlerFunc(AdminUsersHandler)}).Methods("GET")
+	r.Handle("/admin/users/{username}", AdminHandler{http.HandlerFunc(AdminUserHandler)}).Methods("GET")
+	r.Handle("/admin/sync", AdminHandler{http.HandlerFunc(AdminSyncHandler)}).Methods("POST")
+	r.Handle("/admin/history", AdminHandler{http.HandlerFunc(AdminHistoryHandler)}).Methods("GET")
+	r.HandleFunc("/slack/actions", SlackActionHandler).Methods("POST")
+	r.HandleFunc("/slack/events", SlackEventHandler).Methods("POST")
+
+	var loggingHandler http.Handler = handlers.LoggingHandler(os.Stderr, r)
+	if deliveryRecorder != nil {
+		loggingHandler = CaptureHandler{loggingHandler, deliveryRecorder}
+	}
+	var authHandler http.Handler = AuthHandler{loggingHandler}
+	if sourceAllowlist != nil {
+		authHandler = SourceHandler{authHandler, sourceAllowlist}
+	}
+	if requireClientCerts {
+		authHandler = ClientCertHandler{authHandler}
+	}
+	errorHandler := ErrorHandler{LimitHandler{authHandler, config.MaxBodyBytes()}}
+
+	handler := http.NewServeMux()
+	handler.Handle("/", errorHandler)
+	if len(adminCORSOrigins) > 0 {
+		c := cors.New(cors.Options{
+			AllowedOrigins: adminCORSOrigins,
+			AllowedMethods: []string{"GET", "POST"},
+			AllowedHeaders: []string{"accept", "authorization", "content-type"},
+		})
+		handler.Handle("/admin/", c.Handler(errorHandler))
+	}
+	return handler
+}
+
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "ok")
+}
+
+func validatePipeline(event *webhook.PipelineEvent) error {
+	// A pipeline event without a commit isn't something we can report on
+	if event.ObjectAttributes == nil || event.Commit == nil {
+		return errors.New("Not valid or not a pipline request")
+	}
+	return nil
+}
+
+func handlePipeline(w http.ResponseWriter, event *webhook.PipelineEvent) {
+	if err := validatePipeline(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if !root.FailedPipeline() {
-		w.WriteHeader(http.StatusOK)
+	if event.ObjectAttributes.Status != webhook.StatusFailed {
+		writeIgnored(w, "Pipeline didn't fail")
 		return
 	}
 
-	codeAuthor, _ := discoverUsers(&root)
+	codeAuthor := discoverCommitAuthor(event.Commit)
 	if codeAuthor == nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		if _, err := w.Write([]byte("User discovery error")); err != nil {
-			log.Println(err)
-		}
+		writeError(w, http.StatusInternalServerError, "User discovery error")
 		return
 	}
 
@@ -192,114 +329,247 @@ func PipelineWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	// Don't send message if the receiver (codeAuthor) is not an active user
 	if !activeUser(codeAuthor) {
 		log.Printf("Not reporting because %s is not active\n", codeAuthor.GitlabUsername)
-		w.WriteHeader(http.StatusOK)
+		recordPipelineDecision(event, codeAuthor, DecisionSuppressedInactive)
+		writeIgnored(w, "Commit author is not active")
 		return
 	}
 
-	message := fmt.Sprintf("Pipeline failed for your <%s|Commit>", root.Commit.URL)
-	if root.Project != nil && root.ObjectAttributes.Ref != nil {
-		message += fmt.Sprintf(" (%s/%s)", root.Project.Name, *root.ObjectAttributes.Ref)
+	if event.MergeRequest != nil && event.MergeRequest.IsDraft() && !config.NotifyOnDraft(codeAuthor.GitlabUsername) {
+		log.Printf("Not reporting because %s muted their drafts\n", codeAuthor.GitlabUsername)
+		recordPipelineDecision(event, codeAuthor, DecisionSuppressedDraft)
+		writeIgnored(w, "Commit author muted their drafts")
+		return
 	}
-	go slackClient.PostMessage(codeAuthor.SlackID, message, "")
+
+	message := fmt.Sprintf("Pipeline failed for your <%s|Commit>", event.Commit.URL)
+	if event.Project != nil && event.ObjectAttributes.Ref != "" {
+		message += fmt.Sprintf(" (%s/%s)", event.Project.Name, event.ObjectAttributes.Ref)
+	}
+
+	var actions []MessageAction
+	if interactive() && event.Project != nil && event.Project.ID != 0 {
+		actions = append(actions, retryPipelineAction(event.Project.ID, event.ObjectAttributes.ID))
+	}
+	deliver(codeAuthor.SlackID, func() {
+		channel, _ := slackClient.PostInteractiveMessage(codeAuthor.SlackID, message, "", actions)
+		recordPipelineDecision(event, codeAuthor, sentOrFailed(channel))
+	})
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func CommentWebhookHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func validateComment(event *webhook.NoteEvent) error {
+	if event.ObjectAttributes == nil ||
+		(event.MergeRequest == nil && event.Commit == nil && event.Issue == nil && event.Snippet == nil) {
+		return errors.New("Not valid or not a comment request")
+	}
+	return nil
+}
 
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		if _, err := w.Write([]byte("Body must not be empty")); err != nil {
-			log.Println(err)
-		}
+func handleComment(w http.ResponseWriter, event *webhook.NoteEvent) {
+	if err := validateComment(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer func() {
-		if err := r.Body.Close(); err != nil {
-			log.Println("Error closing body:", err)
-		}
-	}()
 
-	var root RootRequest
-	if err := json.NewDecoder(r.Body).Decode(&root); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println(err)
-		if _, err := w.Write([]byte(fmt.Sprintf("JSON decoding error: %v", err))); err != nil {
-			log.Println(err)
-		}
+	if ignoredComment(event) {
+		writeIgnored(w, "Comment is filtered")
 		return
 	}
 
-	// This handler *should* only receive comment updates but we need to still reject everything else.
-	if !root.CommentRequest() || !root.Valid() {
-		w.WriteHeader(http.StatusBadRequest)
-		if _, err := w.Write([]byte("Not valid or not a comment request")); err != nil {
-			log.Println(err)
-		}
+	recipients, commentAuthor := discoverUsers(event)
+	if recipients == nil || commentAuthor == nil {
+		writeError(w, http.StatusInternalServerError, "User discovery error")
 		return
 	}
 
-	codeAuthor, commentAuthor := discoverUsers(&root)
-	if codeAuthor == nil || commentAuthor == nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		if _, err := w.Write([]byte("User discovery error")); err != nil {
-			log.Println(err)
+	var receivers []*User
+	for _, recipient := range recipients {
+		log.Printf("%s made a comment on %s's %s\n",
+			commentAuthor.GitlabUsername, recipient.GitlabUsername, event.ObjectAttributes.NoteableType)
+
+		// Don't send message if the receiver is not an active user
+		// Don't send message if the receiver & commentAuthor are the same person (that got annoying)
+		if !activeUser(recipient) || recipient.Same(commentAuthor) {
+			log.Println("Ignoring the comment")
+			log.Printf("User is not active: %v\n", !activeUser(recipient))
+			log.Printf("Code author is also the comment author: %v\n", recipient.Same(commentAuthor))
+			switch {
+			case recipient.GitlabUsername == "":
+				recordNoteDecision(event, recipient, DecisionSuppressedUnknown, "no Slack account for the author")
+			case !activeUser(recipient):
+				recordNoteDecision(event, recipient, DecisionSuppressedInactive, "")
+			default:
+				recordNoteDecision(event, recipient, DecisionSuppressedSelf, "")
+			}
+			continue
 		}
-		return
+		// Authors can mute what happens on their merge requests while they're still drafts
+		if event.MergeRequest != nil && event.MergeRequest.IsDraft() && !config.NotifyOnDraft(recipient.GitlabUsername) {
+			log.Printf("%s muted comments on their drafts\n", recipient.GitlabUsername)
+			recordNoteDecision(event, recipient, DecisionSuppressedDraft, "")
+			continue
+		}
+		receivers = append(receivers, recipient)
 	}
 
-	log.Printf("%s made a comment on %s MR\n", commentAuthor.GitlabUsername, codeAuthor.GitlabUsername)
+	if len(receivers) == 0 {
+		writeIgnored(w, "Nobody to notify")
+		return
+	}
 
-	// Don't send message if the receiver (codeAuthor) is not an active user
-	// Don't send message if the codeAuthor & commentAuthor are the same person (that got annoying)
-	if !activeUser(codeAuthor) || codeAuthor.Same(commentAuthor) {
-		log.Println("Ignoring the comment")
-		log.Printf("User is not active: %v\n", !activeUser(codeAuthor))
-		log.Printf("Code author is also the comment author: %v\n", codeAuthor.Same(commentAuthor))
+	// A review fires a note per comment, those are collected and sent together once the reviewer is done
+	if window := config.CommentBatchWindow(); window > 0 && event.MergeRequest != nil {
+		pendingComments.Add(window, commentAuthor, receivers, event)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	message := fmt.Sprintf(
-		"%s made a comment on your <%s|Merge Request>",
-		commentAuthor.GitlabUsername, root.ObjectAttributes.URL,
-	)
+	deliverEach(receivers, func(receiver *User) {
+		sendComment(commentAuthor, []*User{receiver}, event)
+	})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// sendComment tells the receivers about a single comment.
+func sendComment(commentAuthor *User, receivers []*User, event *webhook.NoteEvent) {
+	message := commentMessage(commentAuthor, event)
 
 	log.Println("Sending slack message")
 	log.Println(message)
 
-	go slackClient.PostMessage(codeAuthor.SlackID, message, root.ObjectAttributes.Note)
+	projectID := noteProjectID(event)
 
-	w.WriteHeader(http.StatusOK)
+	// Replies in the Slack thread are posted back to Gitlab, see SlackEventHandler
+	target := noteTarget(event)
+	for _, receiver := range receivers {
+		var actions []MessageAction
+		if interactive() && event.MergeRequest != nil {
+			if canApprove(receiver, event.MergeRequest) {
+				actions = append(actions, approveMergeRequestAction(projectID, event.MergeRequest.IID))
+			}
+			if event.ObjectAttributes.DiscussionID != "" {
+				actions = append(actions, resolveDiscussionAction(
+					projectID, event.MergeRequest.IID, event.ObjectAttributes.DiscussionID,
+				))
+			}
+		}
+		channel, timestamp := slackClient.PostInteractiveMessage(receiver.SlackID, message, event.ObjectAttributes.Note, actions)
+		recordNoteDecision(event, receiver, sentOrFailed(channel), "")
+		if channel != "" && target != nil {
+			commentThreads.Remember(channel, timestamp, *target)
+		}
+	}
 }
 
-func discoverUsers(root *RootRequest) (*User, *User) {
-	var codeAuthor User
-	var commentAuthor User
+func commentMessage(commentAuthor *User, event *webhook.NoteEvent) string {
+	noun := "Merge Request"
+	if event.MergeRequest == nil && event.Issue != nil {
+		noun = fmt.Sprintf("Issue #%d", event.Issue.IID)
+	} else if event.MergeRequest == nil && event.Snippet != nil {
+		noun = "Snippet"
+	}
 
-	if users != nil {
-		for _, user := range *users {
-			if root.ObjectAttributes.AuthorID == user.GitlabID {
-				commentAuthor = user
-			}
+	return fmt.Sprintf(
+		"%s made a comment on your <%s|%s>",
+		commentAuthor.GitlabUsername, event.ObjectAttributes.URL, noun,
+	)
+}
 
-			if root.MergeRequest != nil {
-				if root.MergeRequest.AuthorID == user.GitlabID {
-					codeAuthor = user
-				}
-			} else if root.Commit != nil {
-				if root.Commit.Author.Email == user.Email {
-					codeAuthor = user
-				}
-			}
+// discoverUsers finds who should hear about a note and who wrote it, users we can't match are empty.
+// That's the author of the merge request, commit or snippet the note is on, or the author and assignees of the issue.
+func discoverUsers(event *webhook.NoteEvent) ([]*User, *User) {
+	if users == nil {
+		return nil, nil
+	}
+
+	commentAuthor := orEmpty(findUserByID(event.ObjectAttributes.AuthorID))
+
+	switch {
+	case event.MergeRequest != nil:
+		return []*User{orEmpty(findUserByID(event.MergeRequest.AuthorID))}, commentAuthor
+	case event.Commit != nil && event.Commit.Author != nil:
+		return []*User{orEmpty(findUserByEmail(event.Commit.Author.Email))}, commentAuthor
+	case event.Issue != nil:
+		return issueParticipants(event.Issue), commentAuthor
+	case event.Snippet != nil:
+		return []*User{orEmpty(findUserByID(event.Snippet.AuthorID))}, commentAuthor
+	}
+
+	return []*User{{}}, commentAuthor
+}
+
+// issueParticipants are the author and assignees of an issue.
+func issueParticipants(issue *webhook.Issue) []*User {
+	ids := []int{issue.AuthorID}
+	ids = append(ids, issue.AssigneeIDs...)
+	// Older Gitlab versions only have a single assignee
+	if len(issue.AssigneeIDs) == 0 && issue.AssigneeID != nil {
+		ids = append(ids, *issue.AssigneeID)
+	}
+
+	var participants []*User
+	seen := map[int]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		participants = append(participants, orEmpty(findUserByID(id)))
+	}
+	return participants
+}
 
+func orEmpty(user *User) *User {
+	if user == nil {
+		return &User{}
+	}
+	return user
+}
+
+// discoverCommitAuthor finds who wrote a commit, the user is empty when we can't match them.
+func discoverCommitAuthor(commit *webhook.Commit) *User {
+	if users == nil {
+		return nil
+	}
+
+	var author User
+	if commit.Author != nil {
+		for _, user := range *users {
+			if commit.Author.Email == user.Email {
+				author = user
+			}
 		}
+	}
+	return &author
+}
+
+// noteProjectID prefers the project object since older Gitlab versions don't send a top level project_id.
+func noteProjectID(event *webhook.NoteEvent) int {
+	if event.Project != nil && event.Project.ID != 0 {
+		return event.Project.ID
+	}
+	return event.ProjectID
+}
 
-		return &codeAuthor, &commentAuthor
+// noteTarget is where a reply to this comment should go, nil if it isn't a comment we can reply to.
+func noteTarget(event *webhook.NoteEvent) *NoteTarget {
+	if event.ObjectAttributes == nil || noteProjectID(event) == 0 {
+		return nil
 	}
 
-	return nil, nil
+	target := NoteTarget{ProjectID: noteProjectID(event), DiscussionID: event.ObjectAttributes.DiscussionID}
+	if event.MergeRequest != nil {
+		target.MergeRequestIID = event.MergeRequest.IID
+	} else if event.Issue != nil {
+		target.IssueIID = event.Issue.IID
+	} else if event.ObjectAttributes.CommitID != "" {
+		target.CommitSHA = event.ObjectAttributes.CommitID
+	} else {
+		return nil
+	}
+	return &target
 }
 
 func activeUser(user *User) bool {
@@ -314,50 +584,146 @@ func activeUser(user *User) bool {
 	return false
 }
 
+func findUserByUsername(username string) *User {
+	if users == nil {
+		return nil
+	}
+	for _, user := range *users {
+		if user.GitlabUsername == username {
+			u := user
+			return &u
+		}
+	}
+	return nil
+}
+
+func findUserBySlackID(slackID string) *User {
+	if users == nil {
+		return nil
+	}
+	for _, user := range *users {
+		if user.SlackID == slackID {
+			u := user
+			return &u
+		}
+	}
+	return nil
+}
+
+func findUserByID(gitlabID int) *User {
+	if users == nil {
+		return nil
+	}
+	for _, user := range *users {
+		if user.GitlabID == gitlabID {
+			u := user
+			return &u
+		}
+	}
+	return nil
+}
+
+func findUserByEmail(email string) *User {
+	if users == nil {
+		return nil
+	}
+	for _, user := range *users {
+		if strings.EqualFold(user.Email, email) {
+			u := user
+			return &u
+		}
+	}
+	return nil
+}
+
 // TODO: This can be done in parallel
 func populateUsers() {
 	log.Println("Populating users...")
 	defer log.Println("Done populating users")
 
-	slackUsers, err := slackClient.ListUsers()
-	if err != nil || slackUsers == nil {
+	if _, err := refreshUsers(); err != nil {
 		log.Println(err)
-		return
+	}
+}
+
+// refreshUsers replaces the users with a fresh match.
+func refreshUsers() (*UserMatch, error) {
+	match, err := matchUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Found users:", match.Matched)
+	users = &match.Matched
+	lastUserMatch.Store(match)
+	return match, nil
+}
+
+// UserMatch is the outcome of pairing Gitlab and Slack accounts.
+type UserMatch struct {
+	Time    time.Time
+	Matched []User
+	// UnmatchedGitlab and UnmatchedSlack are the accounts without a partner on the other side.
+	UnmatchedGitlab []User
+	UnmatchedSlack  []User
+}
+
+// matchUsers pairs Gitlab and Slack accounts by email.
+func matchUsers() (*UserMatch, error) {
+	slackUsers, err := slackClient.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	if slackUsers == nil {
+		return nil, errors.New("no Slack users")
 	}
 	gitlabUsers, err := gitlabClient.ListUsers()
-	if err != nil || gitlabUsers == nil {
-		log.Println(err)
-		return
+	if err != nil {
+		return nil, err
+	}
+	if gitlabUsers == nil {
+		return nil, errors.New("no Gitlab users")
 	}
 
-	var internalUsers []User
+	match := UserMatch{Time: time.Now()}
+	pairedSlack := map[string]bool{}
 	for _, gu := range *gitlabUsers {
+		found := false
 		for _, su := range *slackUsers {
 			if gu.Email == su.Email {
-				internalUsers = append(internalUsers, User{
+				match.Matched = append(match.Matched, User{
 					Email:          gu.Email,
 					SlackID:        su.SlackID,
 					SlackUsername:  su.SlackUsername,
 					GitlabID:       gu.GitlabID,
 					GitlabUsername: gu.GitlabUsername,
 				})
+				pairedSlack[su.SlackID] = true
+				found = true
 				break
 			}
 		}
+		if !found {
+			match.UnmatchedGitlab = append(match.UnmatchedGitlab, gu)
+		}
+	}
+	for _, su := range *slackUsers {
+		if !pairedSlack[su.SlackID] {
+			match.UnmatchedSlack = append(match.UnmatchedSlack, su)
+		}
 	}
 
-	log.Println("Found users:", internalUsers)
-	users = &internalUsers
+	return &match, nil
 }
 
 // Internal Stuff
 
 type User struct {
-	Email          string
-	SlackID        string
-	SlackUsername  string
-	GitlabID       int
-	GitlabUsername string
+	Email          string `json:"email"`
+	SlackID        string `json:"slack_id"`
+	SlackUsername  string `json:"slack_username"`
+	GitlabID       int    `json:"gitlab_id"`
+	GitlabUsername string `json:"gitlab_username"`
 }
 
 func (u *User) Same(user *User) bool {
@@ -370,150 +736,20 @@ func (u *User) Same(user *User) bool {
 
 // Gitlab Stuff
 
-type RootRequest struct {
-	ObjectKind       string                   `json:"object_kind"`
-	User             *UserRequest             `json:"user"`
-	ProjectID        int                      `json:"project_id"`
-	Project          *ProjectRequest          `json:"project"`
-	Repository       *RepositoryRequest       `json:"repository"`
-	ObjectAttributes *ObjectAttributesRequest `json:"object_attributes"`
-	MergeRequest     *MergeRequestRequest     `json:"merge_request"`
-	Commit           *CommitRequest           `json:"commit"`
-	Builds           *[]BuildRequest          `json:"builds"`
-}
-
-func (root *RootRequest) Valid() bool {
-	return root.ObjectAttributes != nil && (root.MergeRequest != nil || root.Commit != nil)
-}
-
-func (root *RootRequest) CommentRequest() bool {
-	return root.ObjectKind == "note"
-}
-
-func (root *RootRequest) PipelineRequest() bool {
-	return root.ObjectKind == "pipeline"
-}
-
-func (root *RootRequest) FailedPipeline() bool {
-	return root.ObjectAttributes.Status != nil && *root.ObjectAttributes.Status == "failed"
-}
-
-type UserRequest struct {
-	Name      string `json:"name"`
-	Username  string `json:"username"`
-	AvatarURL string `json:"avatar_url"`
-}
-
-type ProjectRequest struct {
-	Name              string  `json:"name"`
-	Description       string  `json:"description"`
-	WebURL            string  `json:"web_url"`
-	AvatarURL         *string `json:"avatar_url"`
-	GitSSHURL         string  `json:"git_ssh_url"`
-	GitHTTPURL        string  `json:"git_http_url"`
-	Namespace         string  `json:"namespace"`
-	VisibilityLevel   int     `json:"visibility_level"`
-	PathWithNamespace string  `json:"path_with_namespace"`
-	DefaultBranch     string  `json:"default_branch"`
-	Homepage          string  `json:"homepage"`
-	URL               string  `json:"url"`
-	SSHURL            string  `json:"ssh_url"`
-	HTTPURL           string  `json:"http_url"`
-}
-
-type RepositoryRequest struct {
-	Name        string `json:"name"`
-	URL         string `json:"url"`
-	Description string `json:"description"`
-	Homepage    string `json:"homepage"`
-}
-
-type ObjectAttributesRequest struct {
-	ID           int            `json:"id"`
-	Note         string         `json:"note"`
-	NoteableType string         `json:"noteable_type"`
-	AuthorID     int            `json:"author_id"`
-	CreatedAt    string         `json"created_at"`
-	UpdatedAt    string         `json:"updated_at"`
-	ProjectID    int            `json:"project_id"`
-	Attachment   *string        `json:"attachment"`
-	LineCode     *string        `json:"line_code"`
-	CommitID     string         `json:"commit_id"`
-	NoteableID   *int           `json:"noteable_id"`
-	System       bool           `json:"system"`
-	StDiff       *StDiffRequest `json:"st_diff"`
-	URL          string         `json:"url"`
-	Status       *string        `json:"status"`
-	Stages       *[]string      `json:"stages"`
-	FinishedAt   *string        `json:"finished_at"`
-	Duration     *int           `json:"duration"`
-	Ref          *string        `json:"ref"`
-}
-
-type StDiffRequest struct {
-	Diff        string `json:"diff"`
-	NewPath     string `json:"new_path"`
-	OldPath     string `json:"old_path"`
-	AMode       string `json:"a_mode"`
-	BMode       string `json:"b_mode"`
-	NewFile     bool   `json:"new_file"`
-	RenamedFile bool   `json:"renamed_file"`
-	DeletedFile bool   `json:"deleted_file"`
-}
-
-type MergeRequestRequest struct {
-	ID              int             `json:"id"`
-	TargetBranch    string          `json:"target_branch"`
-	SourceBranch    string          `json:"source_branch"`
-	SourceProjectID int             `json:"source_project_id"`
-	AuthorID        int             `json:"author_id"`
-	AssigneeID      int             `json:"assignee_id"`
-	Title           string          `json:"title"`
-	CreatedAt       string          `json:"created_at"`
-	UpdatedAt       string          `json"updated_at"`
-	MilestoneID     int             `json:"milestone_id"`
-	State           string          `json:"state"`
-	MergeStatus     string          `json:"merge_status"`
-	TargetProjectID int             `json:"target_project_id"`
-	IID             int             `json:"iid"`
-	Description     string          `json:"description"`
-	Position        int             `json:"position"`
-	LockedAt        *string         `json:"locked_at"`
-	Source          *ProjectRequest `json:"source"`
-	Target          *ProjectRequest `json:"target"`
-	LastCommit      *CommitRequest  `json:"last_commit"`
-	WorkInProgress  bool            `json:"work_in_progress"`
-	Assignee        *UserRequest    `json:"assignee"`
-}
-
-type CommitRequest struct {
-	ID        string         `json:"id"`
-	Message   string         `json:"message"`
-	Timestamp string         `json:"timestamp"`
-	URL       string         `json:"url"`
-	Author    *AuthorRequest `json:"author"`
-}
-
-type AuthorRequest struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
-}
-
-type BuildRequest struct {
-	ID         int          `json:"id"`
-	Stage      string       `json:"stage"`
-	Name       string       `json:"name"`
-	Status     string       `json:"status"`
-	CreatedAt  string       `json:"created_at"`
-	StartedAt  string       `json:"started_at"`
-	FinishedAt string       `json:"finished_at"`
-	When       string       `json:"when"`
-	Manual     bool         `json:"manual"`
-	User       *UserRequest `json:"user"`
-}
-
-type GitlabReader interface {
+// GitlabReadWriter write methods take the Gitlab user ID to act on behalf of (sudo).
+type GitlabReadWriter interface {
 	ListUsers() (*[]User, error)
+	ListOpenMergeRequests(opts ListMergeRequestsOptions) (*[]MergeRequest, error)
+	GetMergeRequestStatus(projectID, iid int) (*MergeRequestStatus, error)
+	RetryPipeline(projectID, pipelineID, sudo int) error
+	ApproveMergeRequest(projectID, iid, sudo int) error
+	ResolveDiscussion(projectID, iid int, discussionID string, sudo int) error
+	CreateNote(target NoteTarget, body string, sudo int) error
+	MergeBase(projectID int, refs ...string) (string, error)
+	ListProtectedBranches(projectID int) (*[]string, error)
+	ListCommitMergeRequests(projectID int, sha string) (*[]MergeRequest, error)
+	CompareCommits(projectID int, from, to string) (*[]Commit, error)
+	ListDeployments(projectID int, environment string) (*[]Deployment, error)
 }
 
 type GitlabClient struct {
@@ -539,33 +775,137 @@ func (client *GitlabClient) ListUsers() (*[]User, error) {
 	return &users, nil
 }
 
+func (client *GitlabClient) RetryPipeline(projectID, pipelineID, sudo int) error {
+	req, err := client.client.NewRequest(
+		"POST", fmt.Sprintf("projects/%d/pipelines/%d/retry", projectID, pipelineID), struct{}{},
+		[]gitlab.OptionFunc{gitlab.WithSudo(sudo)},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.client.Do(req, nil)
+	return err
+}
+
+type createNoteOptions struct {
+	Body string `url:"body" json:"body"`
+}
+
+type createCommitCommentOptions struct {
+	Note string `url:"note" json:"note"`
+}
+
+// CreateNote comments on a merge request, issue or commit, replying to the discussion when the target has one.
+func (client *GitlabClient) CreateNote(target NoteTarget, body string, sudo int) error {
+	var path string
+	var opt interface{} = &createNoteOptions{Body: body}
+
+	switch {
+	case target.MergeRequestIID != 0 && target.DiscussionID != "":
+		path = fmt.Sprintf("projects/%d/merge_requests/%d/discussions/%s/notes",
+			target.ProjectID, target.MergeRequestIID, target.DiscussionID)
+	case target.MergeRequestIID != 0:
+		path = fmt.Sprintf("projects/%d/merge_requests/%d/notes", target.ProjectID, target.MergeRequestIID)
+	case target.IssueIID != 0 && target.DiscussionID != "":
+		path = fmt.Sprintf("projects/%d/issues/%d/discussions/%s/notes",
+			target.ProjectID, target.IssueIID, target.DiscussionID)
+	case target.IssueIID != 0:
+		path = fmt.Sprintf("projects/%d/issues/%d/notes", target.ProjectID, target.IssueIID)
+	case target.CommitSHA != "" && target.DiscussionID != "":
+		path = fmt.Sprintf("projects/%d/repository/commits/%s/discussions/%s/notes",
+			target.ProjectID, target.CommitSHA, target.DiscussionID)
+	case target.CommitSHA != "":
+		path = fmt.Sprintf("projects/%d/repository/commits/%s/comments", target.ProjectID, target.CommitSHA)
+		opt = &createCommitCommentOptions{Note: body}
+	default:
+		return fmt.Errorf("nowhere to post the note")
+	}
+
+	req, err := client.client.NewRequest("POST", path, opt, []gitlab.OptionFunc{gitlab.WithSudo(sudo)})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.client.Do(req, nil)
+	return err
+}
+
+const gitlabBaseURL = "https://gitlab.molecule.io/api/v4/"
+
 func NewGitlabClient(token string) *GitlabClient {
-	git := gitlab.NewClient(nil, token)
-	if err := git.SetBaseURL("https://gitlab.molecule.io/api/v3/"); err != nil {
+	client, err := newGitlabClient(token, gitlabBaseURL)
+	if err != nil {
 		panic(err)
 	}
+	return client
+}
+
+func newGitlabClient(token, baseURL string) (*GitlabClient, error) {
+	git := gitlab.NewClient(nil, token)
+	if err := git.SetBaseURL(baseURL); err != nil {
+		return nil, err
+	}
 
-	return &GitlabClient{git}
+	return &GitlabClient{git}, nil
 }
 
 // Slack Stuff
 
+// SlackReadWriter post methods return the channel and timestamp of the posted message, both empty when it failed.
 type SlackReadWriter interface {
-	PostMessage(channel, message, attachment string)
+	PostMessage(channel, message, attachment string) (string, string)
+	PostInteractiveMessage(channel, message, attachment string, actions []MessageAction) (string, string)
+	AddReaction(channel, timestamp, name string)
 	ListUsers() (*[]User, error)
 }
 
+// MessageAction is a button on a notification, see SlackActionHandler for what happens when it is clicked.
+type MessageAction struct {
+	Name  string
+	Text  string
+	Value string
+	Style string
+}
+
 type SlackClient struct {
 	client *slack.Client
 }
 
-func (client *SlackClient) PostMessage(channel, message, attachment string) {
+func (client *SlackClient) PostMessage(channel, message, attachment string) (string, string) {
 	var attachments []slack.Attachment
 	if attachment != "" {
 		attachments = append(attachments, slack.Attachment{Text: attachment})
 	}
 
-	_, _, err := client.client.PostMessage(
+	return client.postMessage(channel, message, attachments)
+}
+
+func (client *SlackClient) PostInteractiveMessage(channel, message, attachment string, actions []MessageAction) (string, string) {
+	if len(actions) == 0 {
+		return client.PostMessage(channel, message, attachment)
+	}
+
+	buttons := slack.Attachment{CallbackID: slackActionCallbackID, Fallback: message}
+	for _, action := range actions {
+		buttons.Actions = append(buttons.Actions, slack.AttachmentAction{
+			Name:  action.Name,
+			Text:  action.Text,
+			Type:  "button",
+			Value: action.Value,
+			Style: action.Style,
+		})
+	}
+
+	var attachments []slack.Attachment
+	if attachment != "" {
+		attachments = append(attachments, slack.Attachment{Text: attachment})
+	}
+	return client.postMessage(channel, message, append(attachments, buttons))
+}
+
+func (client *SlackClient) postMessage(channel, message string, attachments []slack.Attachment) (string, string) {
+	channelID, timestamp, err := client.client.PostMessage(
 		channel, message,
 		slack.PostMessageParameters{
 			Username:    botName,
@@ -575,6 +915,15 @@ func (client *SlackClient) PostMessage(channel, message, attachment string) {
 	)
 	if err != nil {
 		log.Println(err)
+		return "", ""
+	}
+
+	return channelID, timestamp
+}
+
+func (client *SlackClient) AddReaction(channel, timestamp, name string) {
+	if err := client.client.AddReaction(name, slack.NewRefToMessage(channel, timestamp)); err != nil {
+		log.Println(err)
 	}
 }
 
@@ -614,8 +963,20 @@ func (h AuthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if token, ok := req.Header["X-Gitlab-Token"]; !ok || token[0] != secretToken {
-		w.WriteHeader(http.StatusUnauthorized)
+	// Requests from Slack are signed with the signing secret instead, the handlers check that themselves
+	if req.URL != nil && strings.HasPrefix(req.URL.Path, "/slack/") {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	// Operators use the admin token, see AdminHandler
+	if req.URL != nil && strings.HasPrefix(req.URL.Path, "/admin/") {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	if !validWebhookToken(req) {
+		writeError(w, http.StatusUnauthorized, "Invalid X-Gitlab-Token")
 		return
 	}
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -28,6 +29,7 @@ func TestMain(m *testing.M) {
 		},
 	}
 	activeUsers = users
+	config = &Config{}
 
 	os.Exit(m.Run())
 }
@@ -106,9 +108,9 @@ func TestCommentWebhookHandlerWithInactiveUser(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
 
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusAccepted {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusAccepted)
 	}
 
 	// We don't want to send any slack messages
@@ -169,9 +171,9 @@ func TestPipelineWebhookHandlerWithAFailedPipelineFromAnInactiveUser(t *testing.
 	handler.ServeHTTP(rr, req)
 	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
 
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusAccepted {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusAccepted)
 	}
 
 	if slackStub.receivedChannel == "SLACKID1" {
@@ -199,9 +201,9 @@ func TestPipelineWebhookHandlerWithASuccessfulPipeline(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
 
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusAccepted {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusAccepted)
 	}
 
 	if slackStub.receivedChannel == "SLACKID1" {
@@ -229,9 +231,9 @@ func TestPipelineWebhookHandlerWithARunningPipeline(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
 
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusAccepted {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusAccepted)
 	}
 
 	if slackStub.receivedChannel == "SLACKID1" {
@@ -243,22 +245,156 @@ func TestPipelineWebhookHandlerWithARunningPipeline(t *testing.T) {
 	}
 }
 
+func TestCommentWebhookHandlerFromSnippetComment(t *testing.T) {
+	handler := http.HandlerFunc(CommentWebhookHandler)
+	req, err := http.NewRequest("POST", "/comments", bytes.NewBuffer(SnippetCommentRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	if slackStub.receivedChannel != "SLACKID1" {
+		t.Errorf("slack client received wrong channel: got %v want %v",
+			slackStub.receivedChannel, "SLACKID1")
+	}
+
+	if !strings.Contains(slackStub.receivedMessage, "smeriwether2 made a comment on your <http://example.com/gitlab-org/gitlab-test/-/snippets/53#note_1245|Snippet>") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "smeriwether2 made a comment on your <...|Snippet>")
+	}
+}
+
+// serveEvent posts a Gitlab event to the webhook and waits for the messages it sends.
+func serveEvent(t *testing.T, header string, body []byte) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Gitlab-Event", header)
+	rr := httptest.NewRecorder()
+
+	// Without event workers the event is handled before the response, so only the sends need draining
+	sendWorkers = NewWorkerPool(1, workerQueueSize)
+	defer func() { sendWorkers = nil }()
+	http.HandlerFunc(WebhookHandler).ServeHTTP(rr, req)
+	sendWorkers.Close()
+	return rr
+}
+
 type slackClientStub struct {
 	receivedChannel    string
 	receivedMessage    string
 	receivedAttachment string
+	receivedChannels   []string
+	// receivedMessages are the messages and attachments, in the order they were posted like receivedChannels
+	receivedMessages    []string
+	receivedAttachments []string
+	receivedActions     []MessageAction
+	receivedReactions   []string
 }
 
-func (stub *slackClientStub) PostMessage(channel, message, attachment string) {
+func (stub *slackClientStub) PostMessage(channel, message, attachment string) (string, string) {
 	stub.receivedChannel = channel
 	stub.receivedMessage = message
 	stub.receivedAttachment = attachment
+	stub.receivedChannels = append(stub.receivedChannels, channel)
+	stub.receivedMessages = append(stub.receivedMessages, message)
+	stub.receivedAttachments = append(stub.receivedAttachments, attachment)
+	return "D" + channel, "1500000000.000100"
+}
+
+func (stub *slackClientStub) PostInteractiveMessage(channel, message, attachment string, actions []MessageAction) (string, string) {
+	stub.receivedActions = actions
+	return stub.PostMessage(channel, message, attachment)
+}
+
+func (stub *slackClientStub) AddReaction(channel, timestamp, name string) {
+	stub.receivedReactions = append(stub.receivedReactions, name)
 }
 
 func (stub *slackClientStub) ListUsers() (*[]User, error) {
 	return nil, nil
 }
 
+type gitlabClientStub struct {
+	users               []User
+	mergeRequests       []MergeRequest
+	status              MergeRequestStatus
+	mergeBase           string
+	protectedBranches   []string
+	commitMergeRequests []MergeRequest
+	compareCommits      []Commit
+	deployments         []Deployment
+	err                 error
+	calls               []string
+}
+
+func (stub *gitlabClientStub) ListUsers() (*[]User, error) {
+	if stub.users == nil {
+		return nil, nil
+	}
+	return &stub.users, nil
+}
+
+func (stub *gitlabClientStub) ListOpenMergeRequests(opts ListMergeRequestsOptions) (*[]MergeRequest, error) {
+	return &stub.mergeRequests, nil
+}
+
+func (stub *gitlabClientStub) GetMergeRequestStatus(projectID, iid int) (*MergeRequestStatus, error) {
+	return &stub.status, nil
+}
+
+func (stub *gitlabClientStub) RetryPipeline(projectID, pipelineID, sudo int) error {
+	stub.calls = append(stub.calls, fmt.Sprintf("retry %d/%d as %d", projectID, pipelineID, sudo))
+	return stub.err
+}
+
+func (stub *gitlabClientStub) ApproveMergeRequest(projectID, iid, sudo int) error {
+	stub.calls = append(stub.calls, fmt.Sprintf("approve %d!%d as %d", projectID, iid, sudo))
+	return stub.err
+}
+
+func (stub *gitlabClientStub) CreateNote(target NoteTarget, body string, sudo int) error {
+	stub.calls = append(stub.calls, fmt.Sprintf("note %v %q as %d", target, body, sudo))
+	return stub.err
+}
+
+func (stub *gitlabClientStub) MergeBase(projectID int, refs ...string) (string, error) {
+	return stub.mergeBase, stub.err
+}
+
+func (stub *gitlabClientStub) ListProtectedBranches(projectID int) (*[]string, error) {
+	return &stub.protectedBranches, stub.err
+}
+
+func (stub *gitlabClientStub) ListCommitMergeRequests(projectID int, sha string) (*[]MergeRequest, error) {
+	return &stub.commitMergeRequests, stub.err
+}
+
+func (stub *gitlabClientStub) CompareCommits(projectID int, from, to string) (*[]Commit, error) {
+	stub.calls = append(stub.calls, fmt.Sprintf("compare %d %s...%s", projectID, from, to))
+	return &stub.compareCommits, stub.err
+}
+
+func (stub *gitlabClientStub) ListDeployments(projectID int, environment string) (*[]Deployment, error) {
+	return &stub.deployments, stub.err
+}
+
+func (stub *gitlabClientStub) ResolveDiscussion(projectID, iid int, discussionID string, sudo int) error {
+	stub.calls = append(stub.calls, fmt.Sprintf("resolve %d!%d#%s as %d", projectID, iid, discussionID, sudo))
+	return stub.err
+}
+
 func MergeRequestCommentRequest() []byte {
 	return []byte(
 		`
@@ -836,3 +972,51 @@ func SucessfulPipelineRequest() []byte {
 	`,
 	)
 }
+
+func SnippetCommentRequest() []byte {
+	return []byte(
+		`
+	{
+		"object_kind": "note",
+		"user": {
+			"name": "Stephen 2 Meriwether",
+			"username": "smeriwether2"
+		},
+		"project_id": 5,
+		"project": {
+			"id": 5,
+			"name": "Gitlab Test",
+			"web_url": "http://example.com/gitlab-org/gitlab-test",
+			"path_with_namespace": "gitlab-org/gitlab-test",
+			"default_branch": "master"
+		},
+		"object_attributes": {
+			"id": 1245,
+			"note": "Is this snippet doing what it's supposed to be doing?",
+			"noteable_type": "Snippet",
+			"author_id": 2,
+			"created_at": "2015-05-17 18:35:50 UTC",
+			"updated_at": "2015-05-17 18:35:50 UTC",
+			"project_id": 5,
+			"commit_id": "",
+			"noteable_id": 53,
+			"system": false,
+			"url": "http://example.com/gitlab-org/gitlab-test/-/snippets/53#note_1245"
+		},
+		"snippet": {
+			"id": 53,
+			"title": "test",
+			"content": "puts 'Hello world'",
+			"author_id": 1,
+			"project_id": 5,
+			"created_at": "2015-04-09 02:40:38 UTC",
+			"updated_at": "2015-04-09 02:40:38 UTC",
+			"file_name": "test.rb",
+			"expires_at": null,
+			"type": "ProjectSnippet",
+			"visibility_level": 0
+		}
+	}
+	`,
+	)
+}
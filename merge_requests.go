@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// MergeRequest is the subset of a Gitlab merge request the bot cares about.
+type MergeRequest struct {
+	ID          int
+	IID         int
+	ProjectID   int
+	Title       string
+	Reference   string
+	WebURL      string
+	AuthorID    int
+	AssigneeIDs []int
+	ReviewerIDs []int
+	Draft       bool
+	Labels      []string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ProjectPath returns the project's path with namespace, taken from the merge request's full reference.
+func (mr *MergeRequest) ProjectPath() string {
+	return strings.SplitN(mr.Reference, "!", 2)[0]
+}
+
+func (mr *MergeRequest) HasLabel(label string) bool {
+	for _, l := range mr.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeRequestStatus is the state of a merge request that is expensive to look up.
+type MergeRequestStatus struct {
+	PipelineStatus        string
+	UnresolvedDiscussions int
+}
+
+type ListMergeRequestsOptions struct {
+	AssigneeID int
+	ReviewerID int
+}
+
+type mergeRequestResponse struct {
+	ID             int                     `json:"id"`
+	IID            int                     `json:"iid"`
+	ProjectID      int                     `json:"project_id"`
+	Title          string                  `json:"title"`
+	WebURL         string                  `json:"web_url"`
+	Draft          bool                    `json:"draft"`
+	WorkInProgress bool                    `json:"work_in_progress"`
+	Labels         []string                `json:"labels"`
+	CreatedAt      *time.Time              `json:"created_at"`
+	UpdatedAt      *time.Time              `json:"updated_at"`
+	Author         *memberResponse         `json:"author"`
+	Assignees      []memberResponse        `json:"assignees"`
+	Reviewers      []memberResponse        `json:"reviewers"`
+	HeadPipeline   *pipelineResponse       `json:"head_pipeline"`
+	References     *mergeRequestReferences `json:"references"`
+}
+
+type memberResponse struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type pipelineResponse struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+type mergeRequestReferences struct {
+	Full string `json:"full"`
+}
+
+type discussionResponse struct {
+	ID    string `json:"id"`
+	Notes []struct {
+		Resolvable bool `json:"resolvable"`
+		Resolved   bool `json:"resolved"`
+	} `json:"notes"`
+}
+
+type listMergeRequestsQuery struct {
+	gitlab.ListOptions
+	State      string `url:"state"`
+	Scope      string `url:"scope"`
+	AssigneeID int    `url:"assignee_id,omitempty"`
+	ReviewerID int    `url:"reviewer_id,omitempty"`
+}
+
+func (mr *mergeRequestResponse) toMergeRequest() MergeRequest {
+	merge := MergeRequest{
+		ID:        mr.ID,
+		IID:       mr.IID,
+		ProjectID: mr.ProjectID,
+		Title:     mr.Title,
+		WebURL:    mr.WebURL,
+		Draft:     mr.Draft || mr.WorkInProgress,
+		Labels:    mr.Labels,
+	}
+	if mr.References != nil {
+		merge.Reference = mr.References.Full
+	}
+	if mr.Author != nil {
+		merge.AuthorID = mr.Author.ID
+	}
+	if mr.CreatedAt != nil {
+		merge.CreatedAt = *mr.CreatedAt
+	}
+	if mr.UpdatedAt != nil {
+		merge.UpdatedAt = *mr.UpdatedAt
+	}
+	for _, a := range mr.Assignees {
+		merge.AssigneeIDs = append(merge.AssigneeIDs, a.ID)
+	}
+	for _, r := range mr.Reviewers {
+		merge.ReviewerIDs = append(merge.ReviewerIDs, r.ID)
+	}
+	return merge
+}
+
+// ListOpenMergeRequests lists open merge requests across every project the token can see.
+func (client *GitlabClient) ListOpenMergeRequests(opts ListMergeRequestsOptions) (*[]MergeRequest, error) {
+	query := listMergeRequestsQuery{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		State:       "opened",
+		Scope:       "all",
+		AssigneeID:  opts.AssigneeID,
+		ReviewerID:  opts.ReviewerID,
+	}
+
+	var mergeRequests []MergeRequest
+	for {
+		req, err := client.client.NewRequest("GET", "merge_requests", &query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []mergeRequestResponse
+		resp, err := client.client.Do(req, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, mr := range page {
+			mergeRequests = append(mergeRequests, mr.toMergeRequest())
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		query.Page = resp.NextPage
+	}
+
+	return &mergeRequests, nil
+}
+
+// GetMergeRequestStatus looks up the head pipeline and unresolved discussions of a merge request.
+func (client *GitlabClient) GetMergeRequestStatus(projectID, iid int) (*MergeRequestStatus, error) {
+	req, err := client.client.NewRequest("GET", fmt.Sprintf("projects/%d/merge_requests/%d", projectID, iid), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var mr mergeRequestResponse
+	if _, err := client.client.Do(req, &mr); err != nil {
+		return nil, err
+	}
+
+	status := MergeRequestStatus{}
+	if mr.HeadPipeline != nil {
+		status.PipelineStatus = mr.HeadPipeline.Status
+	}
+
+	query := gitlab.ListOptions{PerPage: 100}
+	for {
+		req, err := client.client.NewRequest(
+			"GET", fmt.Sprintf("projects/%d/merge_requests/%d/discussions", projectID, iid), &query, nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var discussions []discussionResponse
+		resp, err := client.client.Do(req, &discussions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range discussions {
+			for _, n := range d.Notes {
+				if n.Resolvable && !n.Resolved {
+					status.UnresolvedDiscussions++
+					break
+				}
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		query.Page = resp.NextPage
+	}
+
+	return &status, nil
+}
+
+func (client *GitlabClient) ApproveMergeRequest(projectID, iid, sudo int) error {
+	req, err := client.client.NewRequest(
+		"POST", fmt.Sprintf("projects/%d/merge_requests/%d/approve", projectID, iid), struct{}{},
+		[]gitlab.OptionFunc{gitlab.WithSudo(sudo)},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.client.Do(req, nil)
+	return err
+}
+
+type resolveDiscussionOptions struct {
+	Resolved bool `url:"resolved" json:"resolved"`
+}
+
+func (client *GitlabClient) ResolveDiscussion(projectID, iid int, discussionID string, sudo int) error {
+	req, err := client.client.NewRequest(
+		"PUT", fmt.Sprintf("projects/%d/merge_requests/%d/discussions/%s", projectID, iid, discussionID),
+		&resolveDiscussionOptions{Resolved: true}, []gitlab.OptionFunc{gitlab.WithSudo(sudo)},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.client.Do(req, nil)
+	return err
+}
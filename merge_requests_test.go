@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// gitlabServer answers every request with the response for its path and remembers what was asked for.
+func gitlabServer(t *testing.T, responses map[string]string) (*httptest.Server, *GitlabClient, *[]string) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			body = "{}"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+
+	client, err := newGitlabClient("token", server.URL+"/api/v4/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client, &requests
+}
+
+func TestListOpenMergeRequestsUsesTheGlobalV4Endpoint(t *testing.T) {
+	server, client, requests := gitlabServer(t, map[string]string{
+		"/api/v4/merge_requests": `[{"id": 90, "iid": 1, "project_id": 5, "title": "Fix", "draft": true,
+			"references": {"full": "gitlab-org/gitlab-test!1"}, "reviewers": [{"id": 2}]}]`,
+	})
+	defer server.Close()
+
+	mergeRequests, err := client.ListOpenMergeRequests(ListMergeRequestsOptions{ReviewerID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "GET /api/v4/merge_requests?per_page=100&reviewer_id=2&scope=all&state=opened"
+	if len(*requests) != 1 || (*requests)[0] != expected {
+		t.Errorf("client requested wrong path: got %v want %v", *requests, expected)
+	}
+	mr := (*mergeRequests)[0]
+	if mr.Reference != "gitlab-org/gitlab-test!1" || !mr.Draft || len(mr.ReviewerIDs) != 1 {
+		t.Errorf("merge request decoded wrong: %+v", mr)
+	}
+}
+
+func TestGetMergeRequestStatusUsesIIDs(t *testing.T) {
+	server, client, requests := gitlabServer(t, map[string]string{
+		"/api/v4/projects/5/merge_requests/1": `{"id": 90, "iid": 1, "head_pipeline": {"status": "failed"}}`,
+		"/api/v4/projects/5/merge_requests/1/discussions": `[{"id": "abc", "notes": [{"resolvable": true, "resolved": false}]},
+			{"id": "def", "notes": [{"resolvable": true, "resolved": true}]}]`,
+	})
+	defer server.Close()
+
+	status, err := client.GetMergeRequestStatus(5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"GET /api/v4/projects/5/merge_requests/1",
+		"GET /api/v4/projects/5/merge_requests/1/discussions?per_page=100",
+	}
+	if fmt.Sprint(*requests) != fmt.Sprint(expected) {
+		t.Errorf("client requested wrong paths: got %v want %v", *requests, expected)
+	}
+	if status.PipelineStatus != "failed" || status.UnresolvedDiscussions != 1 {
+		t.Errorf("status decoded wrong: %+v", status)
+	}
+}
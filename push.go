@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+const (
+	// Gitlab sends an all zero SHA as before for new refs and as after for deleted refs
+	blankSHA = "0000000000000000000000000000000000000000"
+
+	maxListedCommits = 10
+)
+
+func validatePush(event *webhook.PushEvent) error {
+	if event.Project == nil || event.Ref == "" {
+		return errors.New("Not valid or not a push request")
+	}
+	return nil
+}
+
+func handlePush(w http.ResponseWriter, event *webhook.PushEvent) {
+	if err := validatePush(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if event.ObjectKind == webhook.KindTagPush {
+		deliver(event.Project.PathWithNamespace, func() { announceTag(event) })
+	} else {
+		deliver(event.Project.PathWithNamespace, func() { alertOnPush(event) })
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// announceTag tells the project's release channels about a new tag.
+func announceTag(event *webhook.PushEvent) {
+	if event.After == blankSHA {
+		return
+	}
+
+	channels := config.Project(event.Project.PathWithNamespace).ReleaseChannels
+	if len(channels) == 0 {
+		return
+	}
+
+	tag := strings.TrimPrefix(event.Ref, "refs/tags/")
+	message := fmt.Sprintf(
+		"%s tagged <%s/-/tags/%s|%s> in <%s|%s>",
+		pusherName(event), event.Project.WebURL, tag, tag, event.Project.WebURL, event.Project.PathWithNamespace,
+	)
+
+	log.Printf("Announcing tag %s of %s\n", tag, event.Project.PathWithNamespace)
+	for _, channel := range channels {
+		posted, _ := slackClient.PostMessage(channel, message, "")
+		recordEventDecision(event.ObjectKind, event, channel, sentOrFailed(posted), "")
+	}
+}
+
+// alertOnPush tells the project channel about force pushes and pushes straight to protected branches.
+// Force pushes to any branch are alerted on unless the project only cares about protected ones.
+func alertOnPush(event *webhook.PushEvent) {
+	if event.Before == blankSHA || event.After == blankSHA || !strings.HasPrefix(event.Ref, "refs/heads/") {
+		return
+	}
+
+	project := config.Project(event.Project.PathWithNamespace)
+	if project.Channel == "" {
+		return
+	}
+
+	branch := strings.TrimPrefix(event.Ref, "refs/heads/")
+	projectID := event.ProjectID
+	if event.Project.ID != 0 {
+		projectID = event.Project.ID
+	}
+
+	protected, err := protectedBranch(projectID, branch)
+	if err != nil {
+		log.Println("Error listing protected branches:", err)
+	}
+
+	var alerts []string
+	if protected || !project.ProtectedForcePushesOnly {
+		base, err := gitlabClient.MergeBase(projectID, event.Before, event.After)
+		if err != nil {
+			log.Println("Error finding merge base:", err)
+		} else if base != event.Before {
+			alerts = append(alerts, "force-pushed to")
+		}
+	}
+
+	if protected && len(alerts) == 0 {
+		mergeRequests, err := gitlabClient.ListCommitMergeRequests(projectID, event.After)
+		if err != nil {
+			log.Println("Error listing commit merge requests:", err)
+		} else if mergeRequests == nil || len(*mergeRequests) == 0 {
+			alerts = append(alerts, "pushed directly to protected branch")
+		}
+	}
+
+	if len(alerts) == 0 {
+		return
+	}
+
+	message := fmt.Sprintf(
+		":warning: %s %s `%s` in <%s|%s>",
+		pusherName(event), strings.Join(alerts, " and "), branch, event.Project.WebURL, event.Project.PathWithNamespace,
+	)
+
+	log.Printf("Alerting %s: %s\n", project.Channel, message)
+	channel, _ := slackClient.PostMessage(project.Channel, message, commitList(event))
+	recordEventDecision(event.ObjectKind, event, project.Channel, sentOrFailed(channel), strings.Join(alerts, " and "))
+}
+
+func protectedBranch(projectID int, branch string) (bool, error) {
+	names, err := gitlabClient.ListProtectedBranches(projectID)
+	if err != nil || names == nil {
+		return false, err
+	}
+
+	for _, name := range *names {
+		if matched, _ := path.Match(name, branch); matched || name == branch {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func pusherName(event *webhook.PushEvent) string {
+	if event.UserUsername != "" {
+		return event.UserUsername
+	}
+	return event.UserName
+}
+
+// commitList renders the pushed commits, Gitlab only sends the first 20 so it points out what's missing.
+func commitList(event *webhook.PushEvent) string {
+	commits := event.Commits
+	var lines []string
+	for _, commit := range commits {
+		if len(lines) == maxListedCommits {
+			break
+		}
+		title := strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0]
+		sha := commit.ID
+		if len(sha) > 8 {
+			sha = sha[:8]
+		}
+
+		line := fmt.Sprintf("• <%s|%s> %s", commit.URL, sha, title)
+		if commit.Author != nil {
+			line += fmt.Sprintf(" (%s)", commit.Author.Name)
+		}
+		lines = append(lines, line)
+	}
+
+	total := event.TotalCommitsCount
+	if total < len(commits) {
+		total = len(commits)
+	}
+	if total > len(lines) {
+		lines = append(lines, fmt.Sprintf("and %d more", total-len(lines)))
+	}
+
+	return strings.Join(lines, "\n")
+}
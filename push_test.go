@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushWebhookHandlerWithAForcePush(t *testing.T) {
+	config = &Config{Projects: map[string]ProjectConfig{"mike/diaspora": {Channel: "#diaspora"}}}
+	gitlabClient = &gitlabClientStub{
+		protectedBranches: []string{"release/*", "master"},
+		mergeBase:         "1111111111111111111111111111111111111111",
+	}
+	handler := http.HandlerFunc(PushWebhookHandler)
+	req, err := http.NewRequest("POST", "/push", bytes.NewBuffer(PushRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	if slackStub.receivedChannel != "#diaspora" {
+		t.Errorf("slack client received wrong channel: got %v want %v",
+			slackStub.receivedChannel, "#diaspora")
+	}
+
+	if !strings.Contains(slackStub.receivedMessage, "jsmith force-pushed to `master`") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "jsmith force-pushed to `master`")
+	}
+
+	if !strings.Contains(slackStub.receivedAttachment, "fixed readme (GitLab dev user)") {
+		t.Errorf("slack client received wrong attachment: got %v wanted to include %v",
+			slackStub.receivedAttachment, "fixed readme (GitLab dev user)")
+	}
+}
+
+func TestPushWebhookHandlerWithAForcePushToAFeatureBranch(t *testing.T) {
+	tests := []struct {
+		project  ProjectConfig
+		expected string
+	}{
+		{ProjectConfig{Channel: "#diaspora"}, "jsmith force-pushed to `master`"},
+		{ProjectConfig{Channel: "#diaspora", ProtectedForcePushesOnly: true}, ""},
+	}
+
+	for _, test := range tests {
+		config = &Config{Projects: map[string]ProjectConfig{"mike/diaspora": test.project}}
+		gitlabClient = &gitlabClientStub{
+			protectedBranches: []string{"release/*"},
+			mergeBase:         "1111111111111111111111111111111111111111",
+		}
+		req, err := http.NewRequest("POST", "/push", bytes.NewBuffer(PushRequest()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		slackStub := slackClientStub{}
+		slackClient = &slackStub
+
+		http.HandlerFunc(PushWebhookHandler).ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+		if !strings.Contains(slackStub.receivedMessage, test.expected) || (test.expected == "") != (slackStub.receivedMessage == "") {
+			t.Errorf("%+v: slack client received wrong message: got %q want %q",
+				test.project, slackStub.receivedMessage, test.expected)
+		}
+	}
+}
+
+func TestPushWebhookHandlerWithADirectPushToAProtectedBranch(t *testing.T) {
+	config = &Config{Projects: map[string]ProjectConfig{"mike/diaspora": {Channel: "#diaspora"}}}
+	gitlabClient = &gitlabClientStub{
+		protectedBranches: []string{"master"},
+		mergeBase:         "95790bf891e76fee5e1747ab589903a6a1f80f22",
+	}
+	handler := http.HandlerFunc(PushWebhookHandler)
+	req, err := http.NewRequest("POST", "/push", bytes.NewBuffer(PushRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if !strings.Contains(slackStub.receivedMessage, "jsmith pushed directly to protected branch `master`") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "jsmith pushed directly to protected branch `master`")
+	}
+}
+
+func TestPushWebhookHandlerWithAMergedMergeRequest(t *testing.T) {
+	config = &Config{Projects: map[string]ProjectConfig{"mike/diaspora": {Channel: "#diaspora"}}}
+	gitlabClient = &gitlabClientStub{
+		protectedBranches:   []string{"master"},
+		mergeBase:           "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		commitMergeRequests: []MergeRequest{{ID: 100, IID: 1}},
+	}
+	handler := http.HandlerFunc(PushWebhookHandler)
+	req, err := http.NewRequest("POST", "/push", bytes.NewBuffer(PushRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestPushWebhookHandlerWithATag(t *testing.T) {
+	config = &Config{Projects: map[string]ProjectConfig{"jsmith/example": {ReleaseChannels: []string{"#releases"}}}}
+	handler := http.HandlerFunc(PushWebhookHandler)
+	req, err := http.NewRequest("POST", "/push", bytes.NewBuffer(TagPushRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if slackStub.receivedChannel != "#releases" {
+		t.Errorf("slack client received wrong channel: got %v want %v",
+			slackStub.receivedChannel, "#releases")
+	}
+
+	if !strings.Contains(slackStub.receivedMessage, "jsmith tagged <http://example.com/jsmith/example/-/tags/v1.0.0|v1.0.0>") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "jsmith tagged v1.0.0")
+	}
+}
+
+func PushRequest() []byte {
+	return []byte(
+		`
+		{
+			"object_kind": "push",
+			"event_name": "push",
+			"before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+			"after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+			"ref": "refs/heads/master",
+			"checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+			"user_id": 4,
+			"user_name": "John Smith",
+			"user_username": "jsmith",
+			"user_email": "john@example.com",
+			"user_avatar": "https://s.gravatar.com/avatar/d4c74594d841139328695756648b6bd6?s=8://s.gravatar.com/avatar/d4c74594d841139328695756648b6bd6?s=80",
+			"project_id": 15,
+			"project":{
+				"id": 15,
+				"name":"Diaspora",
+				"description":"",
+				"web_url":"http://example.com/mike/diaspora",
+				"avatar_url":null,
+				"git_ssh_url":"git@example.com:mike/diaspora.git",
+				"git_http_url":"http://example.com/mike/diaspora.git",
+				"namespace":"Mike",
+				"visibility_level":0,
+				"path_with_namespace":"mike/diaspora",
+				"default_branch":"master",
+				"homepage":"http://example.com/mike/diaspora",
+				"url":"git@example.com:mike/diaspora.git",
+				"ssh_url":"git@example.com:mike/diaspora.git",
+				"http_url":"http://example.com/mike/diaspora.git"
+			},
+			"repository":{
+				"name": "Diaspora",
+				"url": "git@example.com:mike/diaspora.git",
+				"description": "",
+				"homepage": "http://example.com/mike/diaspora"
+			},
+			"commits": [
+				{
+					"id": "b6568db1bc1dcd7f8b4d5a946b0b91f9dacd7327",
+					"message": "Update Catalan translation to e38cb41.\n\nSee https://gitlab.com/gitlab-org/gitlab for more information",
+					"title": "Update Catalan translation to e38cb41.",
+					"timestamp": "2011-12-12T14:27:31+02:00",
+					"url": "http://example.com/mike/diaspora/commit/b6568db1bc1dcd7f8b4d5a946b0b91f9dacd7327",
+					"author": {
+						"name": "Jordi Mallach",
+						"email": "jordi@softcatala.org"
+					}
+				},
+				{
+					"id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+					"message": "fixed readme",
+					"title": "fixed readme",
+					"timestamp": "2012-01-03T23:36:29+02:00",
+					"url": "http://example.com/mike/diaspora/commit/da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+					"author": {
+						"name": "GitLab dev user",
+						"email": "gitlabdev@dv6700.(none)"
+					}
+				}
+			],
+			"total_commits_count": 4
+		}
+	`,
+	)
+}
+
+func TagPushRequest() []byte {
+	return []byte(
+		`
+		{
+			"object_kind": "tag_push",
+			"event_name": "tag_push",
+			"before": "0000000000000000000000000000000000000000",
+			"after": "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7",
+			"ref": "refs/tags/v1.0.0",
+			"checkout_sha": "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7",
+			"user_id": 1,
+			"user_name": "John Smith",
+			"user_username": "jsmith",
+			"user_avatar": "https://s.gravatar.com/avatar/d4c74594d841139328695756648b6bd6?s=8://s.gravatar.com/avatar/d4c74594d841139328695756648b6bd6?s=80",
+			"project_id": 1,
+			"project":{
+				"id": 1,
+				"name":"Example",
+				"description":"",
+				"web_url":"http://example.com/jsmith/example",
+				"avatar_url":null,
+				"git_ssh_url":"git@example.com:jsmith/example.git",
+				"git_http_url":"http://example.com/jsmith/example.git",
+				"namespace":"Jsmith",
+				"visibility_level":0,
+				"path_with_namespace":"jsmith/example",
+				"default_branch":"master",
+				"homepage":"http://example.com/jsmith/example",
+				"url":"git@example.com:jsmith/example.git",
+				"ssh_url":"git@example.com:jsmith/example.git",
+				"http_url":"http://example.com/jsmith/example.git"
+			},
+			"repository":{
+				"name": "Example",
+				"url": "ssh://git@example.com/jsmith/example.git",
+				"description": "",
+				"homepage": "http://example.com/jsmith/example",
+				"git_http_url":"http://example.com/jsmith/example.git",
+				"git_ssh_url":"git@example.com:jsmith/example.git",
+				"visibility_level":0
+			},
+			"commits": [],
+			"total_commits_count": 0
+		}
+	`,
+	)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery is a recorded webhook request, replay reads them one JSON object per line.
+type Delivery struct {
+	Time    time.Time         `json:"time,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Headers map[string]string `json:"headers"`
+	// Body is the JSON payload as is, or a string for bodies that aren't JSON.
+	Body json.RawMessage `json:"body"`
+}
+
+// Payload returns the request body the delivery was recorded with.
+func (d *Delivery) Payload() []byte {
+	var text string
+	if err := json.Unmarshal(d.Body, &text); err == nil {
+		return []byte(text)
+	}
+	return d.Body
+}
+
+// replay feeds recorded deliveries through the real handlers, printing what would have been sent to Slack.
+//
+//	gitlab-bot replay [-config config.json] [-users users.json] [-active a,b] [-gitlab] deliveries.jsonl
+func replay(flags *flag.FlagSet, args []string, out io.Writer) error {
+	configPath := flags.String("config", os.Getenv("CONFIG_PATH"), "config file, defaults to CONFIG_PATH")
+	usersPath := flags.String("users", "", "JSON file with the users to match Gitlab and Slack accounts with")
+	active := flags.String("active", os.Getenv("ACTIVE_USERS"), "comma separated active Gitlab usernames, defaults to everyone in -users")
+	online := flags.Bool("gitlab", false, "look things up in Gitlab with GITLAB_TOKEN instead of working offline")
+	wait := flags.Duration("wait", 0, "how long to wait for messages after the last delivery, defaults to the comment batch window plus a second")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	// Messages are printed from the handlers' goroutines while deliveries are still being replayed
+	out = &syncWriter{w: out}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	config = cfg
+
+	users = &[]User{}
+	if *usersPath != "" {
+		if users, err = loadUsers(*usersPath); err != nil {
+			return err
+		}
+	}
+
+	activeUsers = users
+	if *active != "" {
+		var usernames []User
+		for _, username := range strings.Split(*active, ",") {
+			usernames = append(usernames, User{GitlabUsername: strings.TrimSpace(username)})
+		}
+		activeUsers = &usernames
+	}
+
+	gitlabClient = offlineGitlabClient{}
+	if *online {
+		token := os.Getenv("GITLAB_TOKEN")
+		if token == "" {
+			return errors.New("GITLAB_TOKEN must not be empty with -gitlab")
+		}
+		gitlabClient = NewGitlabClient(token)
+	}
+	slackClient = NewDryRunSlackClient(out, nil)
+	secretTokens = parseSecrets(os.Getenv("SECRET_TOKEN"))
+	botName = os.Getenv("BOT_NAME")
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	deliveries, err := readDeliveries(file)
+	if err != nil {
+		return err
+	}
+
+	if *wait == 0 {
+		*wait = config.CommentBatchWindow() + time.Second
+	}
+	return replayDeliveries(deliveries, newHandler(), out, *wait)
+}
+
+func readDeliveries(r io.Reader) ([]Delivery, error) {
+	var deliveries []Delivery
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var delivery Delivery
+		if err := json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, scanner.Err()
+}
+
+func replayDeliveries(deliveries []Delivery, handler http.Handler, out io.Writer, wait time.Duration) error {
+	for i, delivery := range deliveries {
+		method, path := delivery.Method, delivery.Path
+		if method == "" {
+			method = "POST"
+		}
+		if path == "" {
+			path = "/webhook"
+		}
+
+		req, err := http.NewRequest(method, path, bytes.NewReader(delivery.Payload()))
+		if err != nil {
+			return err
+		}
+		for name, value := range delivery.Headers {
+			req.Header.Set(name, value)
+		}
+		// Recorded tokens are redacted, the delivery was authenticated when it was recorded
+		req.Header.Set("X-Gitlab-Token", tokenFor(delivery.Payload()))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		result := fmt.Sprintf("#%d %s %s %s -> %d", i+1, req.Header.Get("X-Gitlab-Event"), method, path, rr.Code)
+		if text := strings.TrimSpace(rr.Body.String()); text != "" {
+			result += " " + text
+		}
+		fmt.Fprintln(out, result)
+	}
+
+	// Messages are sent in the background, and comments might be waiting for more of the same review
+	time.Sleep(wait)
+	return nil
+}
+
+func loadUsers(path string) (*[]User, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var loaded []User
+	if err := json.NewDecoder(file).Decode(&loaded); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", path, err)
+	}
+	return &loaded, nil
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+var errOffline = errors.New("not looking that up in Gitlab while offline")
+
+// offlineGitlabClient fails every call, handlers carry on without whatever they wanted to look up.
+type offlineGitlabClient struct{}
+
+func (offlineGitlabClient) ListUsers() (*[]User, error) { return nil, errOffline }
+func (offlineGitlabClient) ListOpenMergeRequests(opts ListMergeRequestsOptions) (*[]MergeRequest, error) {
+	return nil, errOffline
+}
+func (offlineGitlabClient) GetMergeRequestStatus(projectID, iid int) (*MergeRequestStatus, error) {
+	return nil, errOffline
+}
+func (offlineGitlabClient) RetryPipeline(projectID, pipelineID, sudo int) error { return errOffline }
+func (offlineGitlabClient) ApproveMergeRequest(projectID, iid, sudo int) error  { return errOffline }
+func (offlineGitlabClient) ResolveDiscussion(projectID, iid int, discussionID string, sudo int) error {
+	return errOffline
+}
+func (offlineGitlabClient) CreateNote(target NoteTarget, body string, sudo int) error {
+	return errOffline
+}
+func (offlineGitlabClient) MergeBase(projectID int, refs ...string) (string, error) {
+	return "", errOffline
+}
+func (offlineGitlabClient) ListProtectedBranches(projectID int) (*[]string, error) {
+	return nil, errOffline
+}
+func (offlineGitlabClient) ListCommitMergeRequests(projectID int, sha string) (*[]MergeRequest, error) {
+	return nil, errOffline
+}
+func (offlineGitlabClient) CompareCommits(projectID int, from, to string) (*[]Commit, error) {
+	return nil, errOffline
+}
+func (offlineGitlabClient) ListDeployments(projectID int, environment string) (*[]Deployment, error) {
+	return nil, errOffline
+}
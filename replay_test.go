@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayDeliveries(t *testing.T) {
+	defer func(previous SlackReadWriter) { slackClient = previous }(slackClient)
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	var buf bytes.Buffer
+	out := &syncWriter{w: &buf}
+	slackClient = NewDryRunSlackClient(out, nil)
+
+	body, err := json.Marshal(string(MergeRequestCommentRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := fmt.Sprintf(`{"path": "/comments", "headers": {"X-Gitlab-Event": "Note Hook", "X-Gitlab-Token": "[REDACTED]"}, "body": %s}
+
+{"headers": {"X-Gitlab-Event": "Wiki Page Hook"}, "body": {"object_kind": "push"}}
+`, body)
+
+	deliveries, err := readDeliveries(strings.NewReader(recorded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("read wrong number of deliveries: got %d want 2", len(deliveries))
+	}
+
+	if err := replayDeliveries(deliveries, newHandler(), out, 1*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"#1 Note Hook POST /comments -> 200",
+		"-> SLACKID2: smeriwether1 made a comment on your <http://example.com/gitlab-org/gitlab-test/merge_requests/1#note_1244|Merge Request>",
+		"   | This MR needs work.",
+		`#2 Wiki Page Hook POST /webhook -> 400 {"error":"Wiki Page Hook does not match object kind \"push\""}`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("replay output is missing %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestReadDeliveriesReportsTheBrokenLine(t *testing.T) {
+	_, err := readDeliveries(strings.NewReader("{\"headers\": {}, \"body\": {}}\n{not json\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("expected an error for line 2, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commentThreadMaxAge is how long a comment notification accepts replies from its Slack thread.
+const commentThreadMaxAge = 30 * 24 * time.Hour
+
+var commentThreads = NewThreadStore(commentThreadMaxAge)
+
+// NoteTarget identifies the merge request, commit or issue (and optionally the discussion) a note belongs to.
+type NoteTarget struct {
+	ProjectID       int
+	MergeRequestIID int
+	CommitSHA       string
+	DiscussionID    string
+	IssueIID        int
+}
+
+// ThreadStore remembers which Gitlab comment each Slack message we posted was about.
+type ThreadStore struct {
+	mu      sync.Mutex
+	maxAge  time.Duration
+	threads map[string]storedThread
+}
+
+type storedThread struct {
+	target  NoteTarget
+	created time.Time
+}
+
+func NewThreadStore(maxAge time.Duration) *ThreadStore {
+	return &ThreadStore{maxAge: maxAge, threads: map[string]storedThread{}}
+}
+
+func (store *ThreadStore) Remember(channel, timestamp string, target NoteTarget) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	for key, thread := range store.threads {
+		if now.Sub(thread.created) > store.maxAge {
+			delete(store.threads, key)
+		}
+	}
+	store.threads[channel+":"+timestamp] = storedThread{target: target, created: now}
+}
+
+func (store *ThreadStore) Lookup(channel, timestamp string) (NoteTarget, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	thread, ok := store.threads[channel+":"+timestamp]
+	if !ok || time.Since(thread.created) > store.maxAge {
+		return NoteTarget{}, false
+	}
+	return thread.target, true
+}
+
+type slackEventRequest struct {
+	Type      string     `json:"type"`
+	Challenge string     `json:"challenge"`
+	Event     slackEvent `json:"event"`
+}
+
+type slackEvent struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	Channel  string `json:"channel"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+}
+
+// SlackEventHandler receives Slack Events API callbacks and posts replies in comment threads back to Gitlab.
+func SlackEventHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Println("Error closing body:", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !validSlackSignature(r.Header, body, time.Now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var request slackEventRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte(fmt.Sprintf("JSON decoding error: %v", err))); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	switch request.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		if _, err := w.Write([]byte(request.Challenge)); err != nil {
+			log.Println(err)
+		}
+		return
+	case "event_callback":
+	default:
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Slack retries when we're slow to answer, we already have the first delivery
+	if r.Header.Get("X-Slack-Retry-Num") != "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	event := request.Event
+	if event.Type != "message" || event.Subtype != "" || event.BotID != "" || event.ThreadTS == "" || event.ThreadTS == event.TS {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	target, ok := commentThreads.Lookup(event.Channel, event.ThreadTS)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	go replyToComment(event, target)
+}
+
+func replyToComment(event slackEvent, target NoteTarget) {
+	user := findUserBySlackID(event.User)
+	if user == nil {
+		log.Printf("Slack user %s replied to a comment but isn't mapped to a Gitlab user\n", event.User)
+		return
+	}
+
+	log.Printf("Posting %s's Slack reply to Gitlab\n", user.GitlabUsername)
+	if err := gitlabClient.CreateNote(target, slackToMarkdown(event.Text), user.GitlabID); err != nil {
+		log.Println("Error posting reply:", err)
+		slackClient.AddReaction(event.Channel, event.TS, "warning")
+		return
+	}
+
+	slackClient.AddReaction(event.Channel, event.TS, "white_check_mark")
+}
+
+var (
+	slackLinkPattern    = regexp.MustCompile(`<((?:https?|mailto):[^|>]+)\|([^>]+)>`)
+	slackBareURLPattern = regexp.MustCompile(`<((?:https?|mailto):[^|>]+)>`)
+	slackMentionPattern = regexp.MustCompile(`<@(\w+)(?:\|[^>]*)?>`)
+)
+
+// slackToMarkdown turns Slack's message formatting into the Markdown Gitlab expects.
+func slackToMarkdown(text string) string {
+	text = slackLinkPattern.ReplaceAllString(text, "[$2]($1)")
+	text = slackBareURLPattern.ReplaceAllString(text, "$1")
+	text = slackMentionPattern.ReplaceAllStringFunc(text, func(mention string) string {
+		slackID := slackMentionPattern.FindStringSubmatch(mention)[1]
+		if user := findUserBySlackID(slackID); user != nil {
+			return "@" + user.GitlabUsername
+		}
+		return mention
+	})
+
+	replacer := strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+	return replacer.Replace(text)
+}
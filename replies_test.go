@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCommentWebhookHandlerRemembersThread(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	commentThreads = NewThreadStore(commentThreadMaxAge)
+	handler := http.HandlerFunc(CommentWebhookHandler)
+	req, err := http.NewRequest("POST", "/comments", bytes.NewBuffer(MergeRequestCommentRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	target, ok := commentThreads.Lookup("DSLACKID2", "1500000000.000100")
+	if !ok {
+		t.Fatal("comment thread was not remembered")
+	}
+	if want := (NoteTarget{ProjectID: 10, MergeRequestIID: 1}); target != want {
+		t.Errorf("remembered the wrong target: got %v want %v", target, want)
+	}
+}
+
+func TestSlackEventHandlerPostsThreadReplyToGitlab(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+	commentThreads = NewThreadStore(commentThreadMaxAge)
+	commentThreads.Remember("D024BE91L", "1500000000.000100", NoteTarget{ProjectID: 10, MergeRequestIID: 1, DiscussionID: "abc"})
+	gitlabStub := gitlabClientStub{}
+	gitlabClient = &gitlabStub
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	body := slackEventBody(t, slackEvent{
+		Type:     "message",
+		User:     "SLACKID2",
+		Text:     "Good catch, fixed in <http://example.com/commit/1|this commit>",
+		Channel:  "D024BE91L",
+		TS:       "1500000100.000200",
+		ThreadTS: "1500000000.000100",
+	})
+	req := signedSlackActionRequest(t, body)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SlackEventHandler).ServeHTTP(rr, req)
+	time.Sleep(100 * time.Millisecond) // Sleep to let goroutines finish, this is a code smell :(
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	want := []string{`note {10 1  abc 0} "Good catch, fixed in [this commit](http://example.com/commit/1)" as 2`}
+	if !reflect.DeepEqual(gitlabStub.calls, want) {
+		t.Errorf("gitlab client received wrong calls: got %v want %v", gitlabStub.calls, want)
+	}
+	if want := []string{"white_check_mark"}; !reflect.DeepEqual(slackStub.receivedReactions, want) {
+		t.Errorf("slack client received wrong reactions: got %v want %v", slackStub.receivedReactions, want)
+	}
+}
+
+func TestSlackEventHandlerIgnoresUnknownThreads(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+	commentThreads = NewThreadStore(commentThreadMaxAge)
+	gitlabStub := gitlabClientStub{}
+	gitlabClient = &gitlabStub
+
+	body := slackEventBody(t, slackEvent{
+		Type:     "message",
+		User:     "SLACKID2",
+		Text:     "Unrelated",
+		Channel:  "D024BE91L",
+		TS:       "1500000100.000200",
+		ThreadTS: "1400000000.000100",
+	})
+	req := signedSlackActionRequest(t, body)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SlackEventHandler).ServeHTTP(rr, req)
+	time.Sleep(100 * time.Millisecond) // Sleep to let goroutines finish, this is a code smell :(
+
+	if len(gitlabStub.calls) != 0 {
+		t.Errorf("gitlab client should not have been called: %v", gitlabStub.calls)
+	}
+}
+
+func TestSlackEventHandlerURLVerification(t *testing.T) {
+	slackSigningSecret = "signing-secret"
+	defer func() { slackSigningSecret = "" }()
+
+	req := signedSlackActionRequest(t, `{"type": "url_verification", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SlackEventHandler).ServeHTTP(rr, req)
+
+	if rr.Body.String() != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("handler returned wrong challenge: got %v", rr.Body.String())
+	}
+}
+
+func slackEventBody(t *testing.T, event slackEvent) string {
+	body, err := json.Marshal(map[string]interface{}{
+		"type": "event_callback",
+		"event": map[string]string{
+			"type":      event.Type,
+			"user":      event.User,
+			"text":      event.Text,
+			"channel":   event.Channel,
+			"ts":        event.TS,
+			"thread_ts": event.ThreadTS,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
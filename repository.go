@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// Commit is the subset of a Gitlab commit the bot cares about.
+type Commit struct {
+	ID          string
+	Title       string
+	AuthorName  string
+	AuthorEmail string
+}
+
+type mergeBaseQuery struct {
+	Refs []string `url:"refs[],omitempty"`
+}
+
+type commitResponse struct {
+	ID string `json:"id"`
+}
+
+type compareQuery struct {
+	From string `url:"from"`
+	To   string `url:"to"`
+}
+
+type compareResponse struct {
+	Commits []struct {
+		ID          string `json:"id"`
+		Title       string `json:"title"`
+		AuthorName  string `json:"author_name"`
+		AuthorEmail string `json:"author_email"`
+	} `json:"commits"`
+}
+
+type protectedBranchResponse struct {
+	Name string `json:"name"`
+}
+
+// MergeBase returns the common ancestor of the refs.
+func (client *GitlabClient) MergeBase(projectID int, refs ...string) (string, error) {
+	req, err := client.client.NewRequest(
+		"GET", fmt.Sprintf("projects/%d/repository/merge_base", projectID), &mergeBaseQuery{Refs: refs}, nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var commit commitResponse
+	if _, err := client.client.Do(req, &commit); err != nil {
+		return "", err
+	}
+	return commit.ID, nil
+}
+
+// ListProtectedBranches returns the protected branch names of a project, which may contain wildcards.
+func (client *GitlabClient) ListProtectedBranches(projectID int) (*[]string, error) {
+	query := gitlab.ListOptions{PerPage: 100}
+
+	var names []string
+	for {
+		req, err := client.client.NewRequest(
+			"GET", fmt.Sprintf("projects/%d/protected_branches", projectID), &query, nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var branches []protectedBranchResponse
+		resp, err := client.client.Do(req, &branches)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range branches {
+			names = append(names, b.Name)
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		query.Page = resp.NextPage
+	}
+
+	return &names, nil
+}
+
+// ListCommitMergeRequests returns the merge requests that introduced a commit.
+func (client *GitlabClient) ListCommitMergeRequests(projectID int, sha string) (*[]MergeRequest, error) {
+	req, err := client.client.NewRequest(
+		"GET", fmt.Sprintf("projects/%d/repository/commits/%s/merge_requests", projectID, sha), nil, nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var page []mergeRequestResponse
+	if _, err := client.client.Do(req, &page); err != nil {
+		return nil, err
+	}
+
+	var mergeRequests []MergeRequest
+	for _, mr := range page {
+		mergeRequests = append(mergeRequests, mr.toMergeRequest())
+	}
+	return &mergeRequests, nil
+}
+
+// CompareCommits returns the commits reachable from to but not from from.
+func (client *GitlabClient) CompareCommits(projectID int, from, to string) (*[]Commit, error) {
+	req, err := client.client.NewRequest(
+		"GET", fmt.Sprintf("projects/%d/repository/compare", projectID), &compareQuery{From: from, To: to}, nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var compare compareResponse
+	if _, err := client.client.Do(req, &compare); err != nil {
+		return nil, err
+	}
+
+	var commits []Commit
+	for _, c := range compare.Commits {
+		commits = append(commits, Commit{ID: c.ID, Title: c.Title, AuthorName: c.AuthorName, AuthorEmail: c.AuthorEmail})
+	}
+	return &commits, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+func validateMergeRequest(event *webhook.MergeRequestEvent) error {
+	if event.ObjectAttributes == nil || event.Project == nil {
+		return errors.New("Not valid or not a merge request request")
+	}
+	return nil
+}
+
+// handleMergeRequest tells reviewers when their review is requested and when a draft they review is ready.
+// Nothing is sent while a merge request is a draft, reviewers hear about it once it's ready.
+func handleMergeRequest(w http.ResponseWriter, event *webhook.MergeRequestEvent) {
+	if err := validateMergeRequest(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mr := event.ObjectAttributes
+	if mr.IsDraft() {
+		log.Printf("Not telling reviewers about draft %s!%d\n", event.Project.PathWithNamespace, mr.IID)
+		recordDecision(webhook.KindMergeRequest, event.Project.PathWithNamespace, mr.IID,
+			"", username(event.User), DecisionSuppressedDraft, "reviewers hear about it once it's ready")
+		writeIgnored(w, "Merge request is a draft")
+		return
+	}
+
+	actorName := "Someone"
+	if event.User != nil {
+		actorName = event.User.Username
+	}
+	link := fmt.Sprintf("<%s|%s!%d> %s", mr.URL, event.Project.PathWithNamespace, mr.IID, mr.Title)
+
+	var reviewerIDs []int
+	var message string
+	switch {
+	case leftDraft(event):
+		reviewerIDs = mergeRequestReviewerIDs(event)
+		message = fmt.Sprintf("%s marked %s as ready for review", actorName, link)
+	case mr.Action == "open":
+		reviewerIDs = mergeRequestReviewerIDs(event)
+		message = fmt.Sprintf("%s requested your review on %s", actorName, link)
+	case mr.Action == "update":
+		for _, reviewer := range addedUsers(event.Changes["reviewers"]) {
+			reviewerIDs = append(reviewerIDs, reviewer.ID)
+		}
+		message = fmt.Sprintf("%s requested your review on %s", actorName, link)
+	}
+
+	if len(reviewerIDs) == 0 {
+		writeIgnored(w, "No reviewers to notify")
+		return
+	}
+
+	if users == nil {
+		writeError(w, http.StatusInternalServerError, "User discovery error")
+		return
+	}
+
+	actor := &User{}
+	if event.User != nil {
+		actor = orEmpty(findUserByID(event.User.ID))
+	}
+
+	var receivers []*User
+	for _, id := range reviewerIDs {
+		reviewer := orEmpty(findUserByID(id))
+		// Don't tell anyone about something they did themselves
+		if !activeUser(reviewer) || reviewer.Same(actor) {
+			decision := DecisionSuppressedSelf
+			if !activeUser(reviewer) {
+				decision = DecisionSuppressedInactive
+			}
+			recordDecision(webhook.KindMergeRequest, event.Project.PathWithNamespace, mr.IID,
+				reviewer.GitlabUsername, actorName, decision, "")
+			continue
+		}
+		receivers = append(receivers, reviewer)
+	}
+
+	if len(receivers) == 0 {
+		writeIgnored(w, "Nobody to notify")
+		return
+	}
+
+	deliverEach(receivers, func(receiver *User) {
+		log.Printf("Telling %s: %s\n", receiver.GitlabUsername, message)
+		var actions []MessageAction
+		if interactive() && event.Project.ID != 0 && canApprove(receiver, mr) {
+			actions = append(actions, approveMergeRequestAction(event.Project.ID, mr.IID))
+		}
+		channel, _ := slackClient.PostInteractiveMessage(receiver.SlackID, message, "", actions)
+		recordDecision(webhook.KindMergeRequest, event.Project.PathWithNamespace, mr.IID,
+			receiver.GitlabUsername, actorName, sentOrFailed(channel), "")
+	})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// leftDraft reports whether the update took the merge request out of draft.
+// Older Gitlab versions call it work in progress or only change the title.
+func leftDraft(event *webhook.MergeRequestEvent) bool {
+	if event.ObjectAttributes.Action != "update" {
+		return false
+	}
+
+	for _, key := range []string{"draft", "work_in_progress"} {
+		var previous, current bool
+		change := event.Changes[key]
+		if json.Unmarshal(change.Previous, &previous) == nil && json.Unmarshal(change.Current, &current) == nil {
+			return previous && !current
+		}
+	}
+
+	var previous, current string
+	change := event.Changes["title"]
+	if json.Unmarshal(change.Previous, &previous) == nil && json.Unmarshal(change.Current, &current) == nil {
+		return webhook.DraftTitle(previous) && !webhook.DraftTitle(current)
+	}
+	return false
+}
+
+// mergeRequestReviewerIDs falls back to the reviewers list for Gitlab versions without reviewer_ids.
+func mergeRequestReviewerIDs(event *webhook.MergeRequestEvent) []int {
+	if len(event.ObjectAttributes.ReviewerIDs) > 0 {
+		return event.ObjectAttributes.ReviewerIDs
+	}
+	var ids []int
+	for _, reviewer := range event.Reviewers {
+		ids = append(ids, reviewer.ID)
+	}
+	return ids
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandlerWithAnOpenedMergeRequest(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	rr := serveEvent(t, "Merge Request Hook", MergeRequestRequest("open", false, `{}`))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	if !reflect.DeepEqual(slackStub.receivedChannels, []string{"SLACKID2"}) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, []string{"SLACKID2"})
+	}
+
+	expected := "smeriwether1 requested your review on <http://example.com/gitlab-org/gitlab-test/merge_requests/1|gitlab-org/gitlab-test!1> Add the awesome feature"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+}
+
+func TestWebhookHandlerWithAnAddedReviewer(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	changes := `{"reviewers": {"previous": [], "current": [{"id": 2, "username": "smeriwether2"}]}}`
+	serveEvent(t, "Merge Request Hook", MergeRequestRequest("update", false, changes))
+
+	if !reflect.DeepEqual(slackStub.receivedChannels, []string{"SLACKID2"}) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, []string{"SLACKID2"})
+	}
+}
+
+func TestWebhookHandlerWithAMergeRequestReadyForReview(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	tests := map[string]string{
+		"draft":            `{"draft": {"previous": true, "current": false}}`,
+		"work_in_progress": `{"work_in_progress": {"previous": true, "current": false}}`,
+		"title":            `{"title": {"previous": "Draft: Add the awesome feature", "current": "Add the awesome feature"}}`,
+	}
+
+	for name, changes := range tests {
+		slackStub := slackClientStub{}
+		slackClient = &slackStub
+
+		serveEvent(t, "Merge Request Hook", MergeRequestRequest("update", false, changes))
+
+		expected := "smeriwether1 marked <http://example.com/gitlab-org/gitlab-test/merge_requests/1|gitlab-org/gitlab-test!1> Add the awesome feature as ready for review"
+		if slackStub.receivedMessage != expected {
+			t.Errorf("%s: slack client received wrong message: got %v want %v", name, slackStub.receivedMessage, expected)
+		}
+	}
+}
+
+func TestWebhookHandlerIgnoresDraftMergeRequests(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	changes := `{"reviewers": {"previous": [], "current": [{"id": 2, "username": "smeriwether2"}]}}`
+	serveEvent(t, "Merge Request Hook", MergeRequestRequest("update", true, changes))
+	serveEvent(t, "Merge Request Hook", MergeRequestRequest("open", true, `{}`))
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestCommentWebhookHandlerWithAMutedDraft(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	defer func(previous *Config) { config = previous }(config)
+	notify := false
+	config = &Config{Users: map[string]UserConfig{"smeriwether2": {NotifyOnDraft: &notify}}}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	draft := bytes.Replace(MergeRequestCommentRequest(), []byte(`"work_in_progress": false`), []byte(`"work_in_progress": true`), 1)
+	serveEvent(t, "Note Hook", draft)
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+
+	// The same comment gets through once the merge request isn't a draft anymore
+	serveEvent(t, "Note Hook", MergeRequestCommentRequest())
+
+	if slackStub.receivedChannel != "SLACKID2" {
+		t.Errorf("slack client received wrong channel: got %v want %v", slackStub.receivedChannel, "SLACKID2")
+	}
+}
+
+func TestPipelineWebhookHandlerWithAMutedDraft(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = &[]User{
+		{GitlabUsername: "smeriwether1"},
+	}
+	notify := false
+	config = &Config{Users: map[string]UserConfig{"smeriwether1": {NotifyOnDraft: &notify}}}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	draft := bytes.Replace(FailedPipelineRequest(), []byte(`"object_kind": "pipeline",`), []byte(`"object_kind": "pipeline",
+		"merge_request": {"id": 99, "iid": 1, "title": "Draft: Fix the build", "state": "opened"},`), 1)
+
+	req, err := http.NewRequest("POST", "/pipeline", bytes.NewBuffer(draft))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(PipelineWebhookHandler).ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusAccepted)
+	}
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func MergeRequestRequest(action string, draft bool, changes string) []byte {
+	return []byte(fmt.Sprintf(
+		`
+	{
+		"object_kind": "merge_request",
+		"event_type": "merge_request",
+		"user": {
+			"id": 1,
+			"name": "Stephen 1 Meriwether",
+			"username": "smeriwether1",
+			"email": "stephen1@molecule.io"
+		},
+		"project": {
+			"id": 5,
+			"name": "Gitlab Test",
+			"web_url": "http://example.com/gitlab-org/gitlab-test",
+			"path_with_namespace": "gitlab-org/gitlab-test",
+			"default_branch": "master"
+		},
+		"object_attributes": {
+			"id": 99,
+			"iid": 1,
+			"title": "Add the awesome feature",
+			"state": "opened",
+			"action": %q,
+			"target_branch": "master",
+			"source_branch": "ms-viewport",
+			"source_project_id": 5,
+			"target_project_id": 5,
+			"author_id": 1,
+			"reviewer_ids": [2],
+			"draft": %v,
+			"work_in_progress": %v,
+			"url": "http://example.com/gitlab-org/gitlab-test/merge_requests/1"
+		},
+		"reviewers": [
+			{
+				"id": 2,
+				"name": "Stephen 2 Meriwether",
+				"username": "smeriwether2"
+			}
+		],
+		"changes": %s
+	}
+	`,
+		action, draft, draft, changes,
+	))
+}
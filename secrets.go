@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// secretTokens are the tokens Gitlab may send in X-Gitlab-Token. SECRET_TOKEN can hold several separated
+// by commas, so a new token can be set on the hooks before the old one is removed.
+var secretTokens []string
+
+// parseSecrets splits a comma separated list of secrets. An empty list is kept as a single empty secret
+// so an unset SECRET_TOKEN still only lets through requests with an empty token, like it always has.
+func parseSecrets(value string) []string {
+	var secrets []string
+	for _, secret := range strings.Split(value, ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	if len(secrets) == 0 {
+		return []string{""}
+	}
+	return secrets
+}
+
+// secretsFor returns the tokens accepted for a project's webhooks, its own if it has any and the global ones otherwise.
+func secretsFor(projectID int) []string {
+	if config != nil {
+		if secrets := config.ProjectSecrets[projectID]; len(secrets) > 0 {
+			return secrets
+		}
+	}
+	return secretTokens
+}
+
+// validSecret compares the token to every secret in constant time, so neither the secret nor which one
+// matched can be worked out from how long it took.
+func validSecret(token string, secrets []string) bool {
+	hashed := sha256.Sum256([]byte(token))
+	valid := 0
+	for _, secret := range secrets {
+		expected := sha256.Sum256([]byte(secret))
+		valid |= subtle.ConstantTimeCompare(hashed[:], expected[:])
+	}
+	return valid == 1
+}
+
+// validWebhookToken checks the X-Gitlab-Token of a webhook request. When any project has its own secrets the
+// body is read to find out which project the event is for, and put back for the handlers.
+func validWebhookToken(req *http.Request) bool {
+	tokens, ok := req.Header["X-Gitlab-Token"]
+	if !ok {
+		return false
+	}
+
+	if config == nil || len(config.ProjectSecrets) == 0 || req.Body == nil {
+		return validSecret(tokens[0], secretTokens)
+	}
+
+	// A body that can't be read, say one that's too large, is turned away by the handlers anyway
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body = replayBody(body, err)
+	if err != nil {
+		return validSecret(tokens[0], secretTokens)
+	}
+
+	// Events carry the project in several places, they all have to agree with the token. Without any the
+	// global secrets would let through events for projects that have their own.
+	ids := projectIDs(body)
+	if len(ids) == 0 {
+		return false
+	}
+	for _, id := range ids {
+		if !validSecret(tokens[0], secretsFor(id)) {
+			return false
+		}
+	}
+	return true
+}
+
+// projectIDs returns the project IDs a webhook body claims to be for, including the project of the
+// merge request it's about.
+func projectIDs(body []byte) []int {
+	var event struct {
+		ProjectID int `json:"project_id"`
+		Project   struct {
+			ID int `json:"id"`
+		} `json:"project"`
+		MergeRequest struct {
+			TargetProjectID int `json:"target_project_id"`
+		} `json:"merge_request"`
+		ObjectAttributes struct {
+			TargetProjectID int `json:"target_project_id"`
+		} `json:"object_attributes"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil
+	}
+
+	var ids []int
+	for _, id := range []int{
+		event.Project.ID, event.ProjectID, event.MergeRequest.TargetProjectID, event.ObjectAttributes.TargetProjectID,
+	} {
+		if id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// tokenFor returns a token the bot itself can use to send a webhook body through AuthHandler.
+func tokenFor(body []byte) string {
+	secrets := secretTokens
+	if ids := projectIDs(body); len(ids) > 0 {
+		secrets = secretsFor(ids[0])
+	}
+	if len(secrets) == 0 {
+		return ""
+	}
+	return secrets[0]
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseSecrets(t *testing.T) {
+	tests := []struct {
+		value   string
+		secrets []string
+	}{
+		{"", []string{""}},
+		{"secret", []string{"secret"}},
+		{"new, old,", []string{"new", "old"}},
+	}
+
+	for _, test := range tests {
+		secrets := parseSecrets(test.value)
+		if strings.Join(secrets, "|") != strings.Join(test.secrets, "|") {
+			t.Errorf("parsed %q wrong: got %q want %q", test.value, secrets, test.secrets)
+		}
+	}
+}
+
+func TestAuthHandlerChecksSecretTokens(t *testing.T) {
+	defer func(previous []string) { secretTokens = previous }(secretTokens)
+	defer func(previous *Config) { config = previous }(config)
+	secretTokens = []string{"new", "old"}
+	config = &Config{ProjectSecrets: map[int][]string{15: {"project-secret"}}}
+
+	var received string
+	handler := AuthHandler{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+	})}
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		code  int
+	}{
+		{"current secret", "new", `{"project": {"id": 3}}`, http.StatusOK},
+		{"rotated secret", "old", `{"project": {"id": 3}}`, http.StatusOK},
+		{"wrong secret", "wrong", `{"project": {"id": 3}}`, http.StatusUnauthorized},
+		{"project secret", "project-secret", `{"project_id": 15, "project": {"id": 15}}`, http.StatusOK},
+		{"global secret for a project with its own", "new", `{"project": {"id": 15}}`, http.StatusUnauthorized},
+		{"project secret for another project", "project-secret", `{"project": {"id": 3}}`, http.StatusUnauthorized},
+		{"mismatched project ids", "project-secret", `{"project_id": 3, "project": {"id": 15}}`, http.StatusUnauthorized},
+		{"no project", "new", `{"object_kind": "pipeline"}`, http.StatusUnauthorized},
+		{"merge request of a project with its own secret", "new",
+			`{"project": {"id": 3}, "merge_request": {"target_project_id": 15}}`, http.StatusUnauthorized},
+		{"merge request event of a project with its own secret", "new",
+			`{"project": {"id": 3}, "object_attributes": {"target_project_id": 15}}`, http.StatusUnauthorized},
+		{"merge request of the same project", "project-secret",
+			`{"project": {"id": 15}, "merge_request": {"target_project_id": 15}}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		received = ""
+		req := httptest.NewRequest("POST", "/webhook", strings.NewReader(test.body))
+		req.Header.Set("X-Gitlab-Token", test.token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.code {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, rr.Code, test.code)
+		}
+		if test.code == http.StatusOK && received != test.body {
+			t.Errorf("%s: handler received wrong body: got %q want %q", test.name, received, test.body)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(`{}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code without a token: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLoadConfigRejectsEmptyProjectSecrets(t *testing.T) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(`{"project_secrets": {"15": [""]}}`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if _, err := loadConfig(file.Name()); err == nil || !strings.Contains(err.Error(), "project_secrets.15") {
+		t.Errorf("loadConfig returned wrong error: got %v", err)
+	}
+}
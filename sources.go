@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// sourceAllowlist is set when WEBHOOK_ALLOWED_CIDRS is, webhooks from anywhere else are rejected.
+var sourceAllowlist *SourceAllowlist
+
+// adminCORSOrigins are the origins an admin UI may call the /admin API from, set with ADMIN_CORS_ORIGINS.
+// Nothing else is served with CORS headers, Gitlab doesn't need them to deliver webhooks.
+var adminCORSOrigins []string
+
+// SourceAllowlist decides which addresses webhooks may come from. Requests from a trusted proxy are
+// judged by the address the proxy put in X-Forwarded-For instead.
+type SourceAllowlist struct {
+	allowed        []*net.IPNet
+	trustedProxies []*net.IPNet
+}
+
+// NewSourceAllowlist takes comma separated lists of CIDRs or single addresses.
+func NewSourceAllowlist(allowed, trustedProxies string) (*SourceAllowlist, error) {
+	allowedNets, err := parseNetworks(allowed)
+	if err != nil {
+		return nil, fmt.Errorf("WEBHOOK_ALLOWED_CIDRS: %v", err)
+	}
+	if len(allowedNets) == 0 {
+		return nil, fmt.Errorf("WEBHOOK_ALLOWED_CIDRS must not be empty")
+	}
+	proxyNets, err := parseNetworks(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %v", err)
+	}
+	return &SourceAllowlist{allowed: allowedNets, trustedProxies: proxyNets}, nil
+}
+
+func parseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address a request came from, nil if it can't be worked out. X-Forwarded-For is only
+// believed when the request came through a trusted proxy, and is read from the right skipping any other
+// trusted proxies, since everything left of the last untrusted hop could have been made up by the client.
+func (a *SourceAllowlist) ClientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(a.trustedProxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range req.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil || !containsIP(a.trustedProxies, ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func (a *SourceAllowlist) Allows(req *http.Request) bool {
+	ip := a.ClientIP(req)
+	return ip != nil && containsIP(a.allowed, ip)
+}
+
+// webhookRoute reports whether the request is for a route Gitlab sends to. Health checks, Slack and operators
+// aren't Gitlab, they're checked by their own handlers.
+func webhookRoute(req *http.Request) bool {
+	return req.URL == nil || !(req.URL.Path == "/healthz" ||
+		strings.HasPrefix(req.URL.Path, "/slack/") || strings.HasPrefix(req.URL.Path, "/admin/"))
+}
+
+type SourceHandler struct {
+	handler   http.Handler
+	allowlist *SourceAllowlist
+}
+
+// SourceHandler turns away webhooks from addresses that aren't allowlisted before anything reads them.
+func (h SourceHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !webhookRoute(req) {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	if !h.allowlist.Allows(req) {
+		log.Printf("Rejecting request to %s from %s\n", req.URL.Path, req.RemoteAddr)
+		writeError(w, http.StatusForbidden, "Not an allowed source")
+		return
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSourceAllowlistClientIP(t *testing.T) {
+	allowlist, err := NewSourceAllowlist("34.74.90.64/28, 2001:db8::1", "10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		clientIP     string
+		allowed      bool
+	}{
+		{"direct", "34.74.90.65:4242", nil, "34.74.90.65", true},
+		{"direct ipv6", "[2001:db8::1]:4242", nil, "2001:db8::1", true},
+		{"direct from elsewhere", "192.0.2.1:4242", nil, "192.0.2.1", false},
+		{"forwarded header from an untrusted source", "192.0.2.1:4242", []string{"34.74.90.65"}, "192.0.2.1", false},
+		{"through a trusted proxy", "10.0.0.1:4242", []string{"34.74.90.65"}, "34.74.90.65", true},
+		{"through two trusted proxies", "10.0.0.1:4242", []string{"34.74.90.65, 10.0.0.2"}, "34.74.90.65", true},
+		{"spoofed hop", "10.0.0.1:4242", []string{"34.74.90.65, 192.0.2.1"}, "192.0.2.1", false},
+		{"spoofed hop in another header", "10.0.0.1:4242", []string{"34.74.90.65", "192.0.2.1"}, "192.0.2.1", false},
+		{"garbage hop", "10.0.0.1:4242", []string{"not an ip"}, "<nil>", false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/webhook", nil)
+		req.RemoteAddr = test.remoteAddr
+		for _, header := range test.forwardedFor {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+
+		if ip := allowlist.ClientIP(req).String(); ip != test.clientIP {
+			t.Errorf("%s: found wrong client ip: got %s want %s", test.name, ip, test.clientIP)
+		}
+		if allowed := allowlist.Allows(req); allowed != test.allowed {
+			t.Errorf("%s: allowlist returned wrong answer: got %v want %v", test.name, allowed, test.allowed)
+		}
+	}
+}
+
+func TestNewSourceAllowlistRejectsInvalidNetworks(t *testing.T) {
+	for _, value := range []string{"", "10.0.0.0/33", "gitlab.com"} {
+		if _, err := NewSourceAllowlist(value, ""); err == nil {
+			t.Errorf("NewSourceAllowlist should reject %q", value)
+		}
+	}
+	if _, err := NewSourceAllowlist("10.0.0.0/8", "nope"); err == nil {
+		t.Error("NewSourceAllowlist should reject invalid trusted proxies")
+	}
+}
+
+func TestSourceHandlerRejectsUnexpectedSources(t *testing.T) {
+	allowlist, err := NewSourceAllowlist("34.74.90.64/28", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reached := false
+	handler := SourceHandler{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }), allowlist}
+
+	tests := []struct {
+		path       string
+		remoteAddr string
+		code       int
+		reached    bool
+	}{
+		{"/webhook", "34.74.90.65:4242", http.StatusOK, true},
+		{"/webhook", "192.0.2.1:4242", http.StatusForbidden, false},
+		{"/comments", "192.0.2.1:4242", http.StatusForbidden, false},
+		{"/healthz", "192.0.2.1:4242", http.StatusOK, true},
+		{"/slack/events", "192.0.2.1:4242", http.StatusOK, true},
+		{"/admin/users", "192.0.2.1:4242", http.StatusOK, true},
+	}
+
+	for _, test := range tests {
+		reached = false
+		req := httptest.NewRequest("POST", test.path, nil)
+		req.RemoteAddr = test.remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.code || reached != test.reached {
+			t.Errorf("%s from %s: got %d (reached %v) want %d (reached %v)",
+				test.path, test.remoteAddr, rr.Code, reached, test.code, test.reached)
+		}
+	}
+}
+
+func TestCORSIsOnlyServedForAdminOrigins(t *testing.T) {
+	defer func(previous []string) { adminCORSOrigins = previous }(adminCORSOrigins)
+
+	preflight := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", "https://admin.example.com")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		rr := httptest.NewRecorder()
+		newHandler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	adminCORSOrigins = nil
+	if origin := preflight("/admin/users").Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("CORS should be off by default: got Access-Control-Allow-Origin %q", origin)
+	}
+
+	adminCORSOrigins = []string{"https://admin.example.com"}
+	if origin := preflight("/admin/users").Header().Get("Access-Control-Allow-Origin"); origin != "https://admin.example.com" {
+		t.Errorf("admin preflight returned wrong origin: got %q", origin)
+	}
+	if origin := preflight("/webhook").Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("webhooks should never get CORS headers: got Access-Control-Allow-Origin %q", origin)
+	}
+}
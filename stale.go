@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// staleRemindersSent is the day the stale reminders last went out.
+	staleRemindersSent string
+	// staleEscalated remembers the last activity of every escalated merge request (keyed by ID)
+	// so each stale stretch is only escalated once.
+	staleEscalated = map[int]time.Time{}
+)
+
+// sendDueStaleReminders is called every minute and nudges people about stale merge requests once per weekday.
+func sendDueStaleReminders(now time.Time) {
+	now = now.In(config.Location())
+	if !businessDay(now) {
+		return
+	}
+
+	hour, minute, err := parseClock(config.StaleReminderTime)
+	if err != nil {
+		return
+	}
+	due := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if now.Before(due) || now.Sub(due) >= time.Hour {
+		return
+	}
+
+	today := now.Format("2006-01-02")
+	if staleRemindersSent == today {
+		return
+	}
+	staleRemindersSent = today
+
+	sendStaleReminders(now)
+}
+
+func sendStaleReminders(now time.Time) {
+	log.Println("Looking for stale merge requests...")
+	defer log.Println("Done looking for stale merge requests")
+
+	mergeRequests, err := gitlabClient.ListOpenMergeRequests(ListMergeRequestsOptions{})
+	if err != nil || mergeRequests == nil {
+		log.Println(err)
+		return
+	}
+
+	for _, mr := range *mergeRequests {
+		if mr.Draft || mr.HasLabel(config.SnoozeLabel) {
+			continue
+		}
+
+		idle := businessDaysBetween(mr.UpdatedAt.In(now.Location()), now)
+		if idle < config.StaleAfterDays {
+			continue
+		}
+
+		nudgeStaleMergeRequest(&mr, idle)
+
+		if idle >= config.StaleAfterDays+config.EscalateAfterDays {
+			if last, ok := staleEscalated[mr.ID]; ok && last.Equal(mr.UpdatedAt) {
+				continue
+			}
+			staleEscalated[mr.ID] = mr.UpdatedAt
+			escalateStaleMergeRequest(&mr, idle)
+		}
+	}
+}
+
+// nudgeStaleMergeRequest DMs the author and reviewers of a stale merge request.
+func nudgeStaleMergeRequest(mr *MergeRequest, idle int) {
+	link := fmt.Sprintf("<%s|%s>", mr.WebURL, mergeRequestName(mr))
+
+	if author := findUserByID(mr.AuthorID); author != nil && activeUser(author) {
+		log.Printf("Nudging %s about stale %s\n", author.GitlabUsername, mr.Reference)
+		channel, _ := slackClient.PostMessage(
+			author.SlackID,
+			fmt.Sprintf("Your merge request %s hasn't had any activity for %d business days", link, idle),
+			fmt.Sprintf("Add the %q label to stop these reminders", config.SnoozeLabel),
+		)
+		recordStaleDecision(mr, author.GitlabUsername, sentOrFailed(channel))
+	}
+
+	for _, reviewerID := range mr.ReviewerIDs {
+		reviewer := findUserByID(reviewerID)
+		if reviewer == nil || !activeUser(reviewer) || reviewer.GitlabID == mr.AuthorID {
+			continue
+		}
+		log.Printf("Nudging %s about stale %s\n", reviewer.GitlabUsername, mr.Reference)
+		channel, _ := slackClient.PostMessage(
+			reviewer.SlackID,
+			fmt.Sprintf("%s is waiting on your review and hasn't had any activity for %d business days", link, idle),
+			"",
+		)
+		recordStaleDecision(mr, reviewer.GitlabUsername, sentOrFailed(channel))
+	}
+}
+
+// escalateStaleMergeRequest posts a merge request that has been ignored for too long to its project's channel.
+func escalateStaleMergeRequest(mr *MergeRequest, idle int) {
+	channel := config.ProjectChannel(mr.ProjectPath())
+	if channel == "" {
+		log.Printf("Not escalating %s because %s has no channel\n", mr.Reference, mr.ProjectPath())
+		return
+	}
+
+	message := fmt.Sprintf("<%s|%s> hasn't had any activity for %d business days", mr.WebURL, mergeRequestName(mr), idle)
+	if author := findUserByID(mr.AuthorID); author != nil {
+		message += fmt.Sprintf(" (opened by %s)", author.GitlabUsername)
+	}
+
+	log.Printf("Escalating stale %s to %s\n", mr.Reference, channel)
+	posted, _ := slackClient.PostMessage(channel, message, "")
+	recordStaleDecision(mr, channel, sentOrFailed(posted))
+}
+
+// recordStaleDecision records whether the user, or the channel for escalations, was reminded of a stale merge request.
+func recordStaleDecision(mr *MergeRequest, user, decision string) {
+	recordDecision(EventStale, mr.ProjectPath(), mr.IID, user, "", decision, "")
+}
+
+func mergeRequestName(mr *MergeRequest) string {
+	if mr.Reference == "" {
+		return mr.Title
+	}
+	return fmt.Sprintf("%s %s", mr.Reference, mr.Title)
+}
+
+func businessDay(t time.Time) bool {
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+}
+
+// businessDaysBetween counts the weekdays after from up to and including to.
+func businessDaysBetween(from, to time.Time) int {
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, to.Location())
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, to.Location())
+
+	days := 0
+	for day = day.AddDate(0, 0, 1); !day.After(end); day = day.AddDate(0, 0, 1) {
+		if businessDay(day) {
+			days++
+		}
+	}
+	return days
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendStaleReminders(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	config = &Config{
+		StaleAfterDays:    5,
+		EscalateAfterDays: 5,
+		SnoozeLabel:       "snoozed",
+		Projects: map[string]ProjectConfig{
+			"gitlab-org/gitlab-test": {Channel: "#gitlab-test"},
+		},
+		location: time.UTC,
+	}
+	staleEscalated = map[int]time.Time{}
+	gitlabClient = &gitlabClientStub{
+		mergeRequests: []MergeRequest{
+			{
+				ID: 100, IID: 1, AuthorID: 1, ReviewerIDs: []int{2}, Title: "Stale",
+				Reference: "gitlab-org/gitlab-test!1",
+				UpdatedAt: time.Date(2017, 6, 5, 12, 0, 0, 0, time.UTC),
+			},
+			{
+				ID: 101, IID: 2, AuthorID: 1, ReviewerIDs: []int{2}, Title: "Fresh",
+				Reference: "gitlab-org/gitlab-test!2",
+				UpdatedAt: time.Date(2017, 6, 12, 12, 0, 0, 0, time.UTC),
+			},
+			{
+				ID: 102, IID: 3, AuthorID: 1, Title: "Draft", Draft: true,
+				Reference: "gitlab-org/gitlab-test!3",
+				UpdatedAt: time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC),
+			},
+			{
+				ID: 103, IID: 4, AuthorID: 1, Title: "Snoozed", Labels: []string{"snoozed"},
+				Reference: "gitlab-org/gitlab-test!4",
+				UpdatedAt: time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	// Monday June 12th 2017 is 5 business days after Monday June 5th
+	sendStaleReminders(time.Date(2017, 6, 12, 10, 0, 0, 0, time.UTC))
+
+	if want := []string{"SLACKID1", "SLACKID2"}; !reflect.DeepEqual(slackStub.receivedChannels, want) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, want)
+	}
+	if !strings.Contains(slackStub.receivedMessage, "gitlab-org/gitlab-test!1 Stale") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "gitlab-org/gitlab-test!1 Stale")
+	}
+
+	// A week later it is stale enough to escalate, but only once
+	slackStub = slackClientStub{}
+	slackClient = &slackStub
+	sendStaleReminders(time.Date(2017, 6, 19, 10, 0, 0, 0, time.UTC))
+	sendStaleReminders(time.Date(2017, 6, 20, 10, 0, 0, 0, time.UTC))
+
+	escalations := 0
+	for _, channel := range slackStub.receivedChannels {
+		if channel == "#gitlab-test" {
+			escalations++
+		}
+	}
+	if escalations != 1 {
+		t.Errorf("stale merge request escalated %d times, want 1", escalations)
+	}
+}
+
+func TestBusinessDaysBetween(t *testing.T) {
+	friday := time.Date(2017, 6, 9, 17, 0, 0, 0, time.UTC)
+	tests := []struct {
+		to   time.Time
+		want int
+	}{
+		{friday, 0},
+		{time.Date(2017, 6, 11, 9, 0, 0, 0, time.UTC), 0},
+		{time.Date(2017, 6, 12, 9, 0, 0, 0, time.UTC), 1},
+		{time.Date(2017, 6, 16, 9, 0, 0, 0, time.UTC), 5},
+	}
+
+	for _, test := range tests {
+		if got := businessDaysBetween(friday, test.to); got != test.want {
+			t.Errorf("businessDaysBetween(%v, %v) = %d want %d", friday, test.to, got, test.want)
+		}
+	}
+}
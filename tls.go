@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultListenAddr = ":9090"
+
+// TLSSettings say how the server is secured, they come from SSL_CERT_PATH, SSL_KEY_PATH,
+// TLS_CLIENT_CA_PATH and TLS_REQUIRED.
+type TLSSettings struct {
+	CertPath string
+	KeyPath  string
+	// ClientCAPath turns on client certificate verification, only clients with a certificate signed by
+	// one of the CAs in the PEM file can send webhooks. Slack, health checks and the admin API can't
+	// present one and are checked by their own handlers instead.
+	ClientCAPath string
+	// Required refuses to start the server without TLS instead of falling back to plain HTTP.
+	Required bool
+}
+
+// tlsSettingsFromEnv reads the TLS settings from the environment.
+func tlsSettingsFromEnv() TLSSettings {
+	return TLSSettings{
+		CertPath:     os.Getenv("SSL_CERT_PATH"),
+		KeyPath:      os.Getenv("SSL_KEY_PATH"),
+		ClientCAPath: os.Getenv("TLS_CLIENT_CA_PATH"),
+		Required:     os.Getenv("TLS_REQUIRED") == "true",
+	}
+}
+
+// Config returns the TLS config to serve with, nil means plain HTTP.
+func (s TLSSettings) Config() (*tls.Config, error) {
+	useSSL := true
+	if _, err := os.Stat(s.KeyPath); os.IsNotExist(err) {
+		log.Println("Unable to find ssl key")
+		useSSL = false
+	}
+	if _, err := os.Stat(s.CertPath); os.IsNotExist(err) {
+		log.Println("Unable to find ssl certificate")
+		useSSL = false
+	}
+	if !useSSL {
+		if s.Required {
+			return nil, errors.New("TLS_REQUIRED is set but SSL_CERT_PATH and SSL_KEY_PATH don't both exist")
+		}
+		if s.ClientCAPath != "" {
+			return nil, errors.New("TLS_CLIENT_CA_PATH needs SSL_CERT_PATH and SSL_KEY_PATH")
+		}
+		log.Println("Serving plain HTTP")
+		return nil, nil
+	}
+
+	reloader, err := NewCertReloader(s.CertPath, s.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if s.ClientCAPath != "" {
+		pem, err := ioutil.ReadFile(s.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.ClientCAPath)
+		}
+		tlsConfig.ClientCAs = pool
+		// A certificate that is presented has to be valid, ClientCertHandler turns away webhooks without one
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// requireClientCerts is set when TLS_CLIENT_CA_PATH is, webhooks without a verified client certificate are rejected.
+var requireClientCerts bool
+
+type ClientCertHandler struct {
+	handler http.Handler
+}
+
+// ClientCertHandler turns away webhooks that didn't come with a client certificate signed by a client CA.
+func (h ClientCertHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !webhookRoute(req) {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		log.Printf("Rejecting request to %s from %s without a client certificate\n", req.URL.Path, req.RemoteAddr)
+		writeError(w, http.StatusForbidden, "Client certificate required")
+		return
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+// CertReloader serves a certificate pair from disk and loads it again whenever either file changes,
+// so a renewed certificate is picked up without a restart.
+type CertReloader struct {
+	certPath string
+	keyPath  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	checkedAt time.Time
+}
+
+// certCheckInterval stops every handshake from hitting the disk.
+var certCheckInterval = 10 * time.Second
+
+func NewCertReloader(certPath, keyPath string) (*CertReloader, error) {
+	reloader := &CertReloader{certPath: certPath, keyPath: keyPath}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *CertReloader) reload() error {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+func (r *CertReloader) changed() bool {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)
+}
+
+// GetCertificate is for tls.Config. If the new files can't be loaded, say the certificate was written but
+// the key not yet, the previous certificate is kept until they can.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checkedAt) >= certCheckInterval {
+		r.checkedAt = now
+		if r.changed() {
+			if err := r.reload(); err != nil {
+				log.Println("Error reloading certificate, keeping the previous one:", err)
+			} else {
+				log.Printf("Reloaded certificate %s\n", r.certPath)
+			}
+		}
+	}
+
+	return r.cert, nil
+}
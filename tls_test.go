@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self signed certificate pair with the serial number and returns the cert's PEM.
+func writeCertificate(t *testing.T, certPath, keyPath string, serial int64) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "gitlab-bot"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the modification time moves even on file systems with coarse timestamps
+	modified := time.Now().Add(time.Duration(serial) * time.Second)
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certPEM
+}
+
+func servedSerial(t *testing.T, reloader *CertReloader) int64 {
+	cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestCertReloaderPicksUpNewCertificates(t *testing.T) {
+	defer func(previous time.Duration) { certCheckInterval = previous }(certCheckInterval)
+	certCheckInterval = 0
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeCertificate(t, certPath, keyPath, 1)
+	reloader, err := NewCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := servedSerial(t, reloader); serial != 1 {
+		t.Errorf("served wrong certificate: got serial %d want 1", serial)
+	}
+
+	writeCertificate(t, certPath, keyPath, 2)
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("served wrong certificate after renewal: got serial %d want 2", serial)
+	}
+
+	// A half written renewal keeps the working certificate
+	if err := ioutil.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(keyPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("served wrong certificate after a bad renewal: got serial %d want 2", serial)
+	}
+}
+
+func TestTLSSettingsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	missing := filepath.Join(dir, "missing.pem")
+
+	if tlsConfig, err := (TLSSettings{CertPath: missing, KeyPath: missing}).Config(); tlsConfig != nil || err != nil {
+		t.Errorf("missing certificates should fall back to plain HTTP: got %v, %v", tlsConfig, err)
+	}
+	if _, err := (TLSSettings{CertPath: missing, KeyPath: missing, Required: true}).Config(); err == nil {
+		t.Error("TLS_REQUIRED should refuse to start without certificates")
+	}
+	if _, err := (TLSSettings{CertPath: missing, KeyPath: missing, ClientCAPath: missing}).Config(); err == nil {
+		t.Error("client certificate verification should refuse to start without TLS")
+	}
+
+	caPEM := writeCertificate(t, certPath, keyPath, 1)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := (TLSSettings{CertPath: certPath, KeyPath: keyPath, Required: true}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.GetCertificate == nil || tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("returned wrong TLS config: %+v", tlsConfig)
+	}
+
+	tlsConfig, err = (TLSSettings{CertPath: certPath, KeyPath: keyPath, ClientCAPath: caPath}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven || tlsConfig.ClientCAs == nil {
+		t.Errorf("client certificates aren't verified: %+v", tlsConfig)
+	}
+
+	if _, err := (TLSSettings{CertPath: certPath, KeyPath: keyPath, ClientCAPath: keyPath}).Config(); err == nil {
+		t.Error("a client CA file without certificates should be rejected")
+	}
+}
+
+func TestClientCertificatesAreOnlyRequiredForWebhooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caPEM := writeCertificate(t, certPath, keyPath, 1)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := (TLSSettings{CertPath: certPath, KeyPath: keyPath, ClientCAPath: caPath}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { w.WriteHeader(http.StatusOK) })
+	server := httptest.NewUnstartedServer(ClientCertHandler{ok})
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		certs  []tls.Certificate
+		status int
+	}{
+		{"/slack/events", nil, http.StatusOK},
+		{"/slack/actions", nil, http.StatusOK},
+		{"/healthz", nil, http.StatusOK},
+		{"/webhook", nil, http.StatusForbidden},
+		{"/webhook", []tls.Certificate{clientCert}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates:       test.certs,
+			InsecureSkipVerify: true,
+		}}}
+		resp, err := client.Post(server.URL+test.path, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Errorf("%s with %d certificates: %v", test.path, len(test.certs), err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s with %d certificates: returned wrong status code: got %v want %v",
+				test.path, len(test.certs), resp.StatusCode, test.status)
+		}
+	}
+}
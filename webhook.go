@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+// EventHandler handles one kind of Gitlab event after it has been decoded.
+// The event is the payload struct from the webhook package for that kind.
+type EventHandler struct {
+	// Validate rejects events without what Handle needs, it runs before the event is acknowledged.
+	Validate func(event interface{}) error
+	// Handle writes the response status, for events handled after they were acknowledged it's only logged.
+	Handle func(w http.ResponseWriter, event interface{})
+}
+
+// eventHandlers maps an object_kind to the handler for it, kinds without a handler are acknowledged and ignored.
+var eventHandlers = map[string]EventHandler{
+	webhook.KindNote: {
+		Validate: func(event interface{}) error {
+			return validateComment(event.(*webhook.NoteEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handleComment(w, event.(*webhook.NoteEvent))
+		},
+	},
+	webhook.KindMergeRequest: {
+		Validate: func(event interface{}) error {
+			return validateMergeRequest(event.(*webhook.MergeRequestEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handleMergeRequest(w, event.(*webhook.MergeRequestEvent))
+		},
+	},
+	webhook.KindPipeline: {
+		Validate: func(event interface{}) error {
+			return validatePipeline(event.(*webhook.PipelineEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handlePipeline(w, event.(*webhook.PipelineEvent))
+		},
+	},
+	webhook.KindDeployment: {
+		Validate: func(event interface{}) error {
+			return validateDeployment(event.(*webhook.DeploymentEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handleDeployment(w, event.(*webhook.DeploymentEvent))
+		},
+	},
+	webhook.KindIssue: {
+		Validate: func(event interface{}) error {
+			return validateIssue(event.(*webhook.IssueEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handleIssue(w, event.(*webhook.IssueEvent))
+		},
+	},
+	webhook.KindJob: {
+		Validate: func(event interface{}) error {
+			return validateJob(event.(*webhook.JobEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handleJob(w, event.(*webhook.JobEvent))
+		},
+	},
+	webhook.KindWikiPage: {
+		Validate: func(event interface{}) error {
+			return validateWikiPage(event.(*webhook.WikiPageEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handleWikiPage(w, event.(*webhook.WikiPageEvent))
+		},
+	},
+	webhook.KindPush: {
+		Validate: func(event interface{}) error {
+			return validatePush(event.(*webhook.PushEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handlePush(w, event.(*webhook.PushEvent))
+		},
+	},
+	webhook.KindTagPush: {
+		Validate: func(event interface{}) error {
+			return validatePush(event.(*webhook.PushEvent))
+		},
+		Handle: func(w http.ResponseWriter, event interface{}) {
+			handlePush(w, event.(*webhook.PushEvent))
+		},
+	},
+}
+
+// WebhookHandler accepts every kind of Gitlab event so a single group hook with all triggers enabled just works.
+func WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	serveWebhook(w, r)
+}
+
+// CommentWebhookHandler is the legacy endpoint for note events only.
+func CommentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	serveWebhook(w, r, webhook.KindNote)
+}
+
+// PipelineWebhookHandler is the legacy endpoint for pipeline events only.
+func PipelineWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	serveWebhook(w, r, webhook.KindPipeline)
+}
+
+// PushWebhookHandler is the endpoint for push and tag push events only.
+func PushWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	serveWebhook(w, r, webhook.KindPush, webhook.KindTagPush)
+}
+
+// serveWebhook decodes a Gitlab event and dispatches it, only accepting the given kinds when there are any.
+func serveWebhook(w http.ResponseWriter, r *http.Request, kinds ...string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	kind, event, err := webhook.Parse(r.Header.Get("X-Gitlab-Event"), body)
+	if kindErr, ok := err.(*webhook.KindError); ok {
+		writeError(w, http.StatusBadRequest, kindErr.Error())
+		return
+	}
+	if err != nil && err != webhook.ErrUnsupportedKind {
+		log.Println(err)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("JSON decoding error: %v", err))
+		return
+	}
+
+	if len(kinds) > 0 && !containsString(kinds, kind) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Not a %s request", kinds[0]))
+		return
+	}
+
+	if event != nil {
+		recordWebhook(kind, event)
+	}
+
+	handler, ok := eventHandlers[kind]
+	if !ok || event == nil {
+		log.Printf("Ignoring %q event\n", kind)
+		writeIgnored(w, fmt.Sprintf("%s events aren't handled", kind))
+		return
+	}
+
+	if err := handler.Validate(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Gitlab only has to wait for the event to be checked, not for the users to be looked up and messaged
+	if eventWorkers != nil {
+		job := func() { handler.Handle(newQueuedResponse(kind), event) }
+		if !eventWorkers.SubmitWithin(eventKey(kind, event), job, eventQueueWait) {
+			// Gitlab doesn't send failed deliveries again and disables hooks that keep failing, so the event is
+			// dropped without an error
+			log.Printf("Dropping %s event, the workers are too busy\n", kind)
+			recordEventDecision(kind, event, "", DecisionDropped, "workers too busy")
+			writeIgnored(w, "Too busy, the event was dropped")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"queued": kind})
+		return
+	}
+
+	handler.Handle(w, event)
+}
+
+func readWebhook(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Body == nil {
+		writeError(w, http.StatusBadRequest, "Body must not be empty")
+		return nil, false
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Println("Error closing body:", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Body must not be larger than %d bytes", tooLarge.Limit))
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error reading body: %v", err))
+		return nil, false
+	}
+
+	return body, true
+}
+
+// writeError responds with a JSON body saying what went wrong, Gitlab shows it in the hook's recent events.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeIgnored acknowledges an event the bot has nothing to do for. It's a 202 rather than an error
+// since Gitlab disables hooks that keep failing.
+func writeIgnored(w http.ResponseWriter, reason string) {
+	writeJSON(w, http.StatusAccepted, map[string]string{"ignored": reason})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println(err)
+	}
+}
+
+// replayBody puts back what was read from a request body, followed by the error that stopped the read
+// so whoever reads it next fails the same way.
+func replayBody(body []byte, err error) io.ReadCloser {
+	if err == nil {
+		return ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), errorReader{err}))
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+type LimitHandler struct {
+	handler  http.Handler
+	maxBytes int64
+}
+
+// LimitHandler stops reading request bodies after maxBytes, so nobody can make the bot read a huge payload.
+func (h LimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(w, req.Body, h.maxBytes)
+	}
+	h.handler.ServeHTTP(w, req)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
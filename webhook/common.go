@@ -0,0 +1,101 @@
+package webhook
+
+import "encoding/json"
+
+// User is the user who triggered an event, or one of the assignees or reviewers of an issue or merge request.
+type User struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url"`
+	Email     string `json:"email"`
+}
+
+type Project struct {
+	ID                int     `json:"id"`
+	Name              string  `json:"name"`
+	Description       string  `json:"description"`
+	WebURL            string  `json:"web_url"`
+	AvatarURL         *string `json:"avatar_url"`
+	GitSSHURL         string  `json:"git_ssh_url"`
+	GitHTTPURL        string  `json:"git_http_url"`
+	Namespace         string  `json:"namespace"`
+	VisibilityLevel   int     `json:"visibility_level"`
+	PathWithNamespace string  `json:"path_with_namespace"`
+	DefaultBranch     string  `json:"default_branch"`
+	CIConfigPath      string  `json:"ci_config_path"`
+	Homepage          string  `json:"homepage"`
+	URL               string  `json:"url"`
+	SSHURL            string  `json:"ssh_url"`
+	HTTPURL           string  `json:"http_url"`
+}
+
+type Repository struct {
+	Name            string `json:"name"`
+	URL             string `json:"url"`
+	Description     string `json:"description"`
+	Homepage        string `json:"homepage"`
+	GitHTTPURL      string `json:"git_http_url"`
+	GitSSHURL       string `json:"git_ssh_url"`
+	VisibilityLevel int    `json:"visibility_level"`
+}
+
+type Commit struct {
+	ID        string   `json:"id"`
+	Message   string   `json:"message"`
+	Title     string   `json:"title"`
+	Timestamp Time     `json:"timestamp"`
+	URL       string   `json:"url"`
+	Author    *Author  `json:"author"`
+	Added     []string `json:"added"`
+	Modified  []string `json:"modified"`
+	Removed   []string `json:"removed"`
+}
+
+type Author struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Label struct {
+	ID          int     `json:"id"`
+	Title       string  `json:"title"`
+	Color       string  `json:"color"`
+	ProjectID   *int    `json:"project_id"`
+	CreatedAt   Time    `json:"created_at"`
+	UpdatedAt   Time    `json:"updated_at"`
+	Template    bool    `json:"template"`
+	Description *string `json:"description"`
+	Type        string  `json:"type"`
+	GroupID     *int    `json:"group_id"`
+}
+
+// Changes are the attributes an issue or merge request event changed, keyed by attribute name.
+type Changes map[string]Change
+
+// Change holds the previous and current value of an attribute, decode them into whatever type the attribute has.
+type Change struct {
+	Previous json.RawMessage `json:"previous"`
+	Current  json.RawMessage `json:"current"`
+}
+
+// Has reports whether the attribute changed.
+func (changes Changes) Has(attribute string) bool {
+	_, ok := changes[attribute]
+	return ok
+}
+
+type Runner struct {
+	ID          int      `json:"id"`
+	Description string   `json:"description"`
+	RunnerType  string   `json:"runner_type"`
+	Active      bool     `json:"active"`
+	IsShared    bool     `json:"is_shared"`
+	Tags        []string `json:"tags"`
+}
+
+type Environment struct {
+	Name           string `json:"name"`
+	Action         string `json:"action"`
+	DeploymentTier string `json:"deployment_tier"`
+}
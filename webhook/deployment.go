@@ -0,0 +1,22 @@
+package webhook
+
+// DeploymentEvent is sent when a deployment starts, succeeds, fails or is canceled.
+type DeploymentEvent struct {
+	ObjectKind             string   `json:"object_kind"`
+	Status                 string   `json:"status"`
+	StatusChangedAt        Time     `json:"status_changed_at"`
+	DeploymentID           int      `json:"deployment_id"`
+	DeployableID           int      `json:"deployable_id"`
+	DeployableURL          string   `json:"deployable_url"`
+	Environment            string   `json:"environment"`
+	EnvironmentTier        string   `json:"environment_tier"`
+	EnvironmentSlug        string   `json:"environment_slug"`
+	EnvironmentExternalURL string   `json:"environment_external_url"`
+	Project                *Project `json:"project"`
+	ShortSHA               string   `json:"short_sha"`
+	User                   *User    `json:"user"`
+	UserURL                string   `json:"user_url"`
+	CommitURL              string   `json:"commit_url"`
+	CommitTitle            string   `json:"commit_title"`
+	Ref                    string   `json:"ref"`
+}
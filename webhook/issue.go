@@ -0,0 +1,39 @@
+package webhook
+
+// IssueEvent is sent when an issue is opened, updated, closed or reopened.
+type IssueEvent struct {
+	ObjectKind       string      `json:"object_kind"`
+	EventType        string      `json:"event_type"`
+	User             *User       `json:"user"`
+	Project          *Project    `json:"project"`
+	Repository       *Repository `json:"repository"`
+	ObjectAttributes *Issue      `json:"object_attributes"`
+	Assignees        []User      `json:"assignees"`
+	Assignee         *User       `json:"assignee"`
+	Labels           []Label     `json:"labels"`
+	Changes          Changes     `json:"changes"`
+}
+
+// Issue is the object_attributes of an issue event and the issue of a note event.
+// Action is only sent with issue events.
+type Issue struct {
+	ID           int     `json:"id"`
+	IID          int     `json:"iid"`
+	Title        string  `json:"title"`
+	Description  string  `json:"description"`
+	State        string  `json:"state"`
+	Action       string  `json:"action"`
+	AuthorID     int     `json:"author_id"`
+	AssigneeID   *int    `json:"assignee_id"`
+	AssigneeIDs  []int   `json:"assignee_ids"`
+	ProjectID    int     `json:"project_id"`
+	MilestoneID  *int    `json:"milestone_id"`
+	Confidential bool    `json:"confidential"`
+	DueDate      *string `json:"due_date"`
+	Severity     string  `json:"severity"`
+	CreatedAt    Time    `json:"created_at"`
+	UpdatedAt    Time    `json:"updated_at"`
+	ClosedAt     Time    `json:"closed_at"`
+	URL          string  `json:"url"`
+	Labels       []Label `json:"labels"`
+}
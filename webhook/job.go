@@ -0,0 +1,46 @@
+package webhook
+
+// JobEvent is sent when a job changes status, Gitlab still calls jobs builds in the payload.
+type JobEvent struct {
+	ObjectKind          string       `json:"object_kind"`
+	Ref                 string       `json:"ref"`
+	Tag                 bool         `json:"tag"`
+	BeforeSHA           string       `json:"before_sha"`
+	SHA                 string       `json:"sha"`
+	BuildID             int          `json:"build_id"`
+	BuildName           string       `json:"build_name"`
+	BuildStage          string       `json:"build_stage"`
+	BuildStatus         string       `json:"build_status"`
+	BuildCreatedAt      Time         `json:"build_created_at"`
+	BuildStartedAt      Time         `json:"build_started_at"`
+	BuildFinishedAt     Time         `json:"build_finished_at"`
+	BuildDuration       *float64     `json:"build_duration"`
+	BuildQueuedDuration *float64     `json:"build_queued_duration"`
+	BuildAllowFailure   bool         `json:"build_allow_failure"`
+	BuildFailureReason  string       `json:"build_failure_reason"`
+	RetriesCount        int          `json:"retries_count"`
+	PipelineID          int          `json:"pipeline_id"`
+	ProjectID           int          `json:"project_id"`
+	ProjectName         string       `json:"project_name"`
+	User                *User        `json:"user"`
+	Commit              *JobCommit   `json:"commit"`
+	Repository          *Repository  `json:"repository"`
+	Project             *Project     `json:"project"`
+	Runner              *Runner      `json:"runner"`
+	Environment         *Environment `json:"environment"`
+}
+
+// JobCommit is the commit of a job event, it describes the pipeline more than the commit.
+type JobCommit struct {
+	ID          int     `json:"id"`
+	Name        *string `json:"name"`
+	SHA         string  `json:"sha"`
+	Message     string  `json:"message"`
+	AuthorName  string  `json:"author_name"`
+	AuthorEmail string  `json:"author_email"`
+	AuthorURL   string  `json:"author_url"`
+	Status      string  `json:"status"`
+	Duration    *int    `json:"duration"`
+	StartedAt   Time    `json:"started_at"`
+	FinishedAt  Time    `json:"finished_at"`
+}
@@ -0,0 +1,58 @@
+package webhook
+
+// MergeRequestEvent is sent when a merge request is opened, updated, approved, merged, closed...
+type MergeRequestEvent struct {
+	ObjectKind       string        `json:"object_kind"`
+	EventType        string        `json:"event_type"`
+	User             *User         `json:"user"`
+	Project          *Project      `json:"project"`
+	Repository       *Repository   `json:"repository"`
+	ObjectAttributes *MergeRequest `json:"object_attributes"`
+	Labels           []Label       `json:"labels"`
+	Changes          Changes       `json:"changes"`
+	Assignees        []User        `json:"assignees"`
+	Reviewers        []User        `json:"reviewers"`
+}
+
+// MergeRequest is the object_attributes of a merge request event and the merge_request of a note event.
+// Action and OldRev are only sent with merge request events.
+type MergeRequest struct {
+	ID                          int      `json:"id"`
+	IID                         int      `json:"iid"`
+	Title                       string   `json:"title"`
+	Description                 string   `json:"description"`
+	State                       string   `json:"state"`
+	Action                      string   `json:"action"`
+	OldRev                      string   `json:"oldrev"`
+	TargetBranch                string   `json:"target_branch"`
+	SourceBranch                string   `json:"source_branch"`
+	SourceProjectID             int      `json:"source_project_id"`
+	TargetProjectID             int      `json:"target_project_id"`
+	AuthorID                    int      `json:"author_id"`
+	AssigneeID                  *int     `json:"assignee_id"`
+	AssigneeIDs                 []int    `json:"assignee_ids"`
+	ReviewerIDs                 []int    `json:"reviewer_ids"`
+	MilestoneID                 *int     `json:"milestone_id"`
+	HeadPipelineID              *int     `json:"head_pipeline_id"`
+	MergeStatus                 string   `json:"merge_status"`
+	DetailedMergeStatus         string   `json:"detailed_merge_status"`
+	MergeCommitSHA              *string  `json:"merge_commit_sha"`
+	WorkInProgress              bool     `json:"work_in_progress"`
+	Draft                       bool     `json:"draft"`
+	BlockingDiscussionsResolved bool     `json:"blocking_discussions_resolved"`
+	CreatedAt                   Time     `json:"created_at"`
+	UpdatedAt                   Time     `json:"updated_at"`
+	LastEditedAt                Time     `json:"last_edited_at"`
+	LockedAt                    Time     `json:"locked_at"`
+	URL                         string   `json:"url"`
+	Source                      *Project `json:"source"`
+	Target                      *Project `json:"target"`
+	LastCommit                  *Commit  `json:"last_commit"`
+	Assignee                    *User    `json:"assignee"`
+	Labels                      []Label  `json:"labels"`
+}
+
+// IsDraft covers Gitlab versions from before work in progress was renamed to draft.
+func (mr *MergeRequest) IsDraft() bool {
+	return mr.Draft || mr.WorkInProgress
+}
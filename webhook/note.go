@@ -0,0 +1,85 @@
+package webhook
+
+// NoteEvent is sent for comments on commits, merge requests, issues and snippets.
+// Exactly one of Commit, MergeRequest, Issue and Snippet is set, depending on Note.NoteableType.
+type NoteEvent struct {
+	ObjectKind       string        `json:"object_kind"`
+	EventType        string        `json:"event_type"`
+	User             *User         `json:"user"`
+	ProjectID        int           `json:"project_id"`
+	Project          *Project      `json:"project"`
+	Repository       *Repository   `json:"repository"`
+	ObjectAttributes *Note         `json:"object_attributes"`
+	Commit           *Commit       `json:"commit"`
+	MergeRequest     *MergeRequest `json:"merge_request"`
+	Issue            *Issue        `json:"issue"`
+	Snippet          *Snippet      `json:"snippet"`
+}
+
+type Note struct {
+	ID           int       `json:"id"`
+	Note         string    `json:"note"`
+	NoteableType string    `json:"noteable_type"`
+	NoteableID   *int      `json:"noteable_id"`
+	AuthorID     int       `json:"author_id"`
+	CreatedAt    Time      `json:"created_at"`
+	UpdatedAt    Time      `json:"updated_at"`
+	ProjectID    int       `json:"project_id"`
+	Attachment   *string   `json:"attachment"`
+	LineCode     *string   `json:"line_code"`
+	CommitID     string    `json:"commit_id"`
+	System       bool      `json:"system"`
+	StDiff       *StDiff   `json:"st_diff"`
+	Position     *Position `json:"position"`
+	DiscussionID string    `json:"discussion_id"`
+	Type         *string   `json:"type"`
+	Action       string    `json:"action"`
+	URL          string    `json:"url"`
+}
+
+// Noteable types of Note.NoteableType.
+const (
+	NoteableCommit       = "Commit"
+	NoteableMergeRequest = "MergeRequest"
+	NoteableIssue        = "Issue"
+	NoteableSnippet      = "Snippet"
+)
+
+type StDiff struct {
+	Diff        string `json:"diff"`
+	NewPath     string `json:"new_path"`
+	OldPath     string `json:"old_path"`
+	AMode       string `json:"a_mode"`
+	BMode       string `json:"b_mode"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+}
+
+// Position is where a diff comment was left.
+type Position struct {
+	BaseSHA      string `json:"base_sha"`
+	StartSHA     string `json:"start_sha"`
+	HeadSHA      string `json:"head_sha"`
+	OldPath      string `json:"old_path"`
+	NewPath      string `json:"new_path"`
+	PositionType string `json:"position_type"`
+	OldLine      *int   `json:"old_line"`
+	NewLine      *int   `json:"new_line"`
+}
+
+type Snippet struct {
+	ID              int    `json:"id"`
+	Title           string `json:"title"`
+	Description     string `json:"description"`
+	Content         string `json:"content"`
+	AuthorID        int    `json:"author_id"`
+	ProjectID       int    `json:"project_id"`
+	CreatedAt       Time   `json:"created_at"`
+	UpdatedAt       Time   `json:"updated_at"`
+	FileName        string `json:"file_name"`
+	ExpiresAt       Time   `json:"expires_at"`
+	Type            string `json:"type"`
+	VisibilityLevel int    `json:"visibility_level"`
+	URL             string `json:"url"`
+}
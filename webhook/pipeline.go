@@ -0,0 +1,101 @@
+package webhook
+
+import "strings"
+
+// PipelineEvent is sent when a pipeline changes status.
+type PipelineEvent struct {
+	ObjectKind       string                `json:"object_kind"`
+	ObjectAttributes *Pipeline             `json:"object_attributes"`
+	MergeRequest     *PipelineMergeRequest `json:"merge_request"`
+	User             *User                 `json:"user"`
+	Project          *Project              `json:"project"`
+	Commit           *Commit               `json:"commit"`
+	Builds           []Build               `json:"builds"`
+}
+
+type Pipeline struct {
+	ID             int        `json:"id"`
+	IID            int        `json:"iid"`
+	Name           *string    `json:"name"`
+	Ref            string     `json:"ref"`
+	Tag            bool       `json:"tag"`
+	SHA            string     `json:"sha"`
+	BeforeSHA      string     `json:"before_sha"`
+	Source         string     `json:"source"`
+	Status         string     `json:"status"`
+	DetailedStatus string     `json:"detailed_status"`
+	Stages         []string   `json:"stages"`
+	CreatedAt      Time       `json:"created_at"`
+	FinishedAt     Time       `json:"finished_at"`
+	Duration       *int       `json:"duration"`
+	QueuedDuration *int       `json:"queued_duration"`
+	Variables      []Variable `json:"variables"`
+	URL            string     `json:"url"`
+}
+
+type Variable struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// PipelineMergeRequest is the merge request a merge request pipeline ran for.
+type PipelineMergeRequest struct {
+	ID                  int    `json:"id"`
+	IID                 int    `json:"iid"`
+	Title               string `json:"title"`
+	SourceBranch        string `json:"source_branch"`
+	SourceProjectID     int    `json:"source_project_id"`
+	TargetBranch        string `json:"target_branch"`
+	TargetProjectID     int    `json:"target_project_id"`
+	State               string `json:"state"`
+	MergeStatus         string `json:"merge_status"`
+	DetailedMergeStatus string `json:"detailed_merge_status"`
+	URL                 string `json:"url"`
+}
+
+// draftPrefixes mark a merge request title as a draft, pipeline events don't say so any other way.
+var draftPrefixes = []string{"draft:", "[draft]", "(draft)", "wip:", "[wip]"}
+
+// IsDraft tells from the title whether the merge request is a draft.
+func (mr *PipelineMergeRequest) IsDraft() bool {
+	return DraftTitle(mr.Title)
+}
+
+// DraftTitle reports whether a merge request title marks it as a draft (e.g. "Draft: Fix the build").
+func DraftTitle(title string) bool {
+	title = strings.ToLower(strings.TrimSpace(title))
+	for _, prefix := range draftPrefixes {
+		if strings.HasPrefix(title, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build is one of the jobs of a pipeline event.
+type Build struct {
+	ID             int          `json:"id"`
+	Stage          string       `json:"stage"`
+	Name           string       `json:"name"`
+	Status         string       `json:"status"`
+	CreatedAt      Time         `json:"created_at"`
+	StartedAt      Time         `json:"started_at"`
+	FinishedAt     Time         `json:"finished_at"`
+	Duration       *float64     `json:"duration"`
+	QueuedDuration *float64     `json:"queued_duration"`
+	FailureReason  *string      `json:"failure_reason"`
+	When           string       `json:"when"`
+	Manual         bool         `json:"manual"`
+	AllowFailure   bool         `json:"allow_failure"`
+	User           *User        `json:"user"`
+	Runner         *Runner      `json:"runner"`
+	Environment    *Environment `json:"environment"`
+}
+
+// Pipeline statuses we care about, see Pipeline.Status.
+const (
+	StatusFailed  = "failed"
+	StatusSuccess = "success"
+	StatusRunning = "running"
+	StatusManual  = "manual"
+)
@@ -0,0 +1,24 @@
+package webhook
+
+// PushEvent is sent for pushes to branches and, with object kind tag_push, for pushed tags.
+// Gitlab only sends the first 20 commits, TotalCommitsCount has the real number.
+type PushEvent struct {
+	ObjectKind        string      `json:"object_kind"`
+	EventName         string      `json:"event_name"`
+	Before            string      `json:"before"`
+	After             string      `json:"after"`
+	Ref               string      `json:"ref"`
+	RefProtected      bool        `json:"ref_protected"`
+	CheckoutSHA       *string     `json:"checkout_sha"`
+	Message           *string     `json:"message"`
+	UserID            int         `json:"user_id"`
+	UserName          string      `json:"user_name"`
+	UserUsername      string      `json:"user_username"`
+	UserEmail         string      `json:"user_email"`
+	UserAvatar        string      `json:"user_avatar"`
+	ProjectID         int         `json:"project_id"`
+	Project           *Project    `json:"project"`
+	Repository        *Repository `json:"repository"`
+	Commits           []Commit    `json:"commits"`
+	TotalCommitsCount int         `json:"total_commits_count"`
+}
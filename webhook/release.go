@@ -0,0 +1,36 @@
+package webhook
+
+// ReleaseEvent is sent when a release is created, updated or deleted.
+type ReleaseEvent struct {
+	ObjectKind  string         `json:"object_kind"`
+	ID          int            `json:"id"`
+	Action      string         `json:"action"`
+	Name        string         `json:"name"`
+	Tag         string         `json:"tag"`
+	Description string         `json:"description"`
+	CreatedAt   Time           `json:"created_at"`
+	ReleasedAt  Time           `json:"released_at"`
+	URL         string         `json:"url"`
+	Project     *Project       `json:"project"`
+	Commit      *Commit        `json:"commit"`
+	Assets      *ReleaseAssets `json:"assets"`
+}
+
+type ReleaseAssets struct {
+	Count   int             `json:"count"`
+	Links   []ReleaseLink   `json:"links"`
+	Sources []ReleaseSource `json:"sources"`
+}
+
+type ReleaseLink struct {
+	ID       int    `json:"id"`
+	External bool   `json:"external"`
+	LinkType string `json:"link_type"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+}
+
+type ReleaseSource struct {
+	Format string `json:"format"`
+	URL    string `json:"url"`
+}
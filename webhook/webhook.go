@@ -0,0 +1,119 @@
+// Package webhook has typed payloads for the Gitlab webhook events we receive, see
+// https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Object kinds Gitlab sends in the body of each event.
+const (
+	KindPush         = "push"
+	KindTagPush      = "tag_push"
+	KindNote         = "note"
+	KindIssue        = "issue"
+	KindMergeRequest = "merge_request"
+	KindWikiPage     = "wiki_page"
+	KindPipeline     = "pipeline"
+	KindJob          = "build"
+	KindDeployment   = "deployment"
+	KindRelease      = "release"
+)
+
+// ErrUnsupportedKind is returned by Parse for kinds this package has no payload for.
+var ErrUnsupportedKind = errors.New("Unsupported event kind")
+
+// eventKinds maps the X-Gitlab-Event header to the object_kind Gitlab sends in the body.
+var eventKinds = map[string]string{
+	"Push Hook":               KindPush,
+	"Tag Push Hook":           KindTagPush,
+	"Note Hook":               KindNote,
+	"Confidential Note Hook":  KindNote,
+	"Issue Hook":              KindIssue,
+	"Confidential Issue Hook": KindIssue,
+	"Merge Request Hook":      KindMergeRequest,
+	"Wiki Page Hook":          KindWikiPage,
+	"Pipeline Hook":           KindPipeline,
+	"Job Hook":                KindJob,
+	"Deployment Hook":         KindDeployment,
+	"Release Hook":            KindRelease,
+}
+
+// KindError is returned by Parse when the kind of an event is unknown or the X-Gitlab-Event header
+// contradicts the object_kind in the body.
+type KindError struct {
+	Header     string
+	ObjectKind string
+}
+
+func (err *KindError) Error() string {
+	if err.ObjectKind == "" {
+		return "Unknown event"
+	}
+	return fmt.Sprintf("%s does not match object kind %q", err.Header, err.ObjectKind)
+}
+
+// Parse works out the kind of an event and decodes it, returning a pointer to its payload struct (*PushEvent,
+// *NoteEvent, ...). For kinds without a payload struct it returns the kind along with ErrUnsupportedKind.
+func Parse(header string, body []byte) (string, interface{}, error) {
+	var envelope struct {
+		ObjectKind string `json:"object_kind"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return "", nil, err
+	}
+
+	kind, err := eventKind(header, envelope.ObjectKind)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var event interface{}
+	switch kind {
+	case KindPush, KindTagPush:
+		event = &PushEvent{}
+	case KindNote:
+		event = &NoteEvent{}
+	case KindIssue:
+		event = &IssueEvent{}
+	case KindMergeRequest:
+		event = &MergeRequestEvent{}
+	case KindWikiPage:
+		event = &WikiPageEvent{}
+	case KindPipeline:
+		event = &PipelineEvent{}
+	case KindJob:
+		event = &JobEvent{}
+	case KindDeployment:
+		event = &DeploymentEvent{}
+	case KindRelease:
+		event = &ReleaseEvent{}
+	default:
+		return kind, nil, ErrUnsupportedKind
+	}
+
+	if err := json.Unmarshal(body, event); err != nil {
+		return kind, nil, err
+	}
+	return kind, event, nil
+}
+
+// eventKind checks the X-Gitlab-Event header agrees with the object_kind of the body.
+// Either one is enough on its own, older Gitlab versions don't send the header.
+func eventKind(header, objectKind string) (string, error) {
+	expected, known := eventKinds[header]
+
+	if objectKind == "" {
+		if !known {
+			return "", &KindError{Header: header}
+		}
+		return expected, nil
+	}
+
+	if known && expected != objectKind {
+		return "", &KindError{Header: header, ObjectKind: objectKind}
+	}
+	return objectKind, nil
+}
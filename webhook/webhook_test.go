@@ -0,0 +1,320 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSamplePayloads(t *testing.T) {
+	tests := []struct {
+		file   string
+		header string
+		check  func(t *testing.T, event interface{})
+	}{
+		{"push.json", "Push Hook", func(t *testing.T, event interface{}) {
+			push := event.(*PushEvent)
+			if push.Ref != "refs/heads/master" || push.UserUsername != "jsmith" || push.Project.ID != 15 {
+				t.Errorf("push decoded wrong: %+v", push)
+			}
+			if len(push.Commits) != 2 || push.TotalCommitsCount != 4 || push.Commits[0].Author.Email != "jordi@softcatala.org" {
+				t.Errorf("push commits decoded wrong: %+v", push.Commits)
+			}
+			expectTime(t, push.Commits[0].Timestamp, "2011-12-12T12:27:31Z")
+		}},
+		{"tag_push.json", "Tag Push Hook", func(t *testing.T, event interface{}) {
+			push := event.(*PushEvent)
+			if push.ObjectKind != KindTagPush || push.Ref != "refs/tags/v1.0.0" || *push.Message != "Tag message" {
+				t.Errorf("tag push decoded wrong: %+v", push)
+			}
+		}},
+		{"note_merge_request.json", "Note Hook", func(t *testing.T, event interface{}) {
+			note := event.(*NoteEvent)
+			if note.ObjectAttributes.NoteableType != NoteableMergeRequest || note.ObjectAttributes.AuthorID != 1 {
+				t.Errorf("note decoded wrong: %+v", note.ObjectAttributes)
+			}
+			if note.ObjectAttributes.DiscussionID == "" || *note.ObjectAttributes.Position.NewLine != 12 {
+				t.Errorf("diff note decoded wrong: %+v", note.ObjectAttributes)
+			}
+			if note.MergeRequest.IID != 1 || note.MergeRequest.AuthorID != 8 || len(note.MergeRequest.ReviewerIDs) != 2 {
+				t.Errorf("merge request decoded wrong: %+v", note.MergeRequest)
+			}
+			if note.MergeRequest.Labels[0].Title != "Afterpod" {
+				t.Errorf("labels decoded wrong: %+v", note.MergeRequest.Labels)
+			}
+			expectTime(t, note.ObjectAttributes.CreatedAt, "2015-05-17T18:21:36Z")
+			expectTime(t, note.MergeRequest.UpdatedAt, "2015-03-21T18:27:27Z")
+		}},
+		{"note_commit.json", "Note Hook", func(t *testing.T, event interface{}) {
+			note := event.(*NoteEvent)
+			if note.ObjectAttributes.NoteableType != NoteableCommit || note.Commit == nil || note.MergeRequest != nil {
+				t.Errorf("commit note decoded wrong: %+v", note)
+			}
+			if note.ObjectAttributes.StDiff.NewPath != "six" || note.Commit.Author.Email != "user@example.com" {
+				t.Errorf("commit note decoded wrong: %+v", note.ObjectAttributes)
+			}
+		}},
+		{"note_issue.json", "Confidential Note Hook", func(t *testing.T, event interface{}) {
+			note := event.(*NoteEvent)
+			if note.ObjectAttributes.NoteableType != NoteableIssue || note.Issue.IID != 17 || note.Issue.AuthorID != 8 {
+				t.Errorf("issue note decoded wrong: %+v", note.Issue)
+			}
+			if !note.Issue.ClosedAt.IsZero() {
+				t.Errorf("null closed_at should be zero: %v", note.Issue.ClosedAt)
+			}
+		}},
+		{"note_snippet.json", "Note Hook", func(t *testing.T, event interface{}) {
+			note := event.(*NoteEvent)
+			if note.ObjectAttributes.NoteableType != NoteableSnippet || note.Snippet.FileName != "test.rb" {
+				t.Errorf("snippet note decoded wrong: %+v", note.Snippet)
+			}
+		}},
+		{"merge_request.json", "Merge Request Hook", func(t *testing.T, event interface{}) {
+			mr := event.(*MergeRequestEvent)
+			if mr.ObjectAttributes.Action != "update" || mr.ObjectAttributes.IID != 1 || mr.ObjectAttributes.IsDraft() {
+				t.Errorf("merge request decoded wrong: %+v", mr.ObjectAttributes)
+			}
+			if len(mr.Reviewers) != 1 || mr.Reviewers[0].Username != "user1" || *mr.ObjectAttributes.HeadPipelineID != 61 {
+				t.Errorf("merge request people decoded wrong: %+v", mr)
+			}
+
+			var wasDraft bool
+			if err := json.Unmarshal(mr.Changes["draft"].Previous, &wasDraft); err != nil || !wasDraft {
+				t.Errorf("draft change decoded wrong: %s", mr.Changes["draft"].Previous)
+			}
+			var reviewers []User
+			if err := json.Unmarshal(mr.Changes["reviewers"].Current, &reviewers); err != nil || len(reviewers) != 1 {
+				t.Errorf("reviewers change decoded wrong: %s", mr.Changes["reviewers"].Current)
+			}
+			if mr.Changes.Has("title") {
+				t.Errorf("title didn't change")
+			}
+			expectTime(t, mr.ObjectAttributes.CreatedAt, "2013-12-03T17:23:34Z")
+		}},
+		{"issue.json", "Issue Hook", func(t *testing.T, event interface{}) {
+			issue := event.(*IssueEvent)
+			if issue.ObjectAttributes.Action != "open" || issue.ObjectAttributes.IID != 23 || issue.ObjectAttributes.AssigneeIDs[0] != 51 {
+				t.Errorf("issue decoded wrong: %+v", issue.ObjectAttributes)
+			}
+			if len(issue.Assignees) != 1 || issue.Labels[0].Title != "API" {
+				t.Errorf("issue people decoded wrong: %+v", issue)
+			}
+		}},
+		{"pipeline.json", "Pipeline Hook", func(t *testing.T, event interface{}) {
+			pipeline := event.(*PipelineEvent)
+			if pipeline.ObjectAttributes.Status != StatusFailed || pipeline.ObjectAttributes.Ref != "master" || *pipeline.ObjectAttributes.Duration != 63 {
+				t.Errorf("pipeline decoded wrong: %+v", pipeline.ObjectAttributes)
+			}
+			if pipeline.MergeRequest.IID != 1 || pipeline.Commit.Author.Email != "user@gitlab.com" {
+				t.Errorf("pipeline merge request decoded wrong: %+v", pipeline.MergeRequest)
+			}
+			if len(pipeline.Builds) != 2 || !pipeline.Builds[0].Manual || *pipeline.Builds[1].Duration != 17.1 {
+				t.Errorf("pipeline builds decoded wrong: %+v", pipeline.Builds)
+			}
+			if !pipeline.Builds[0].StartedAt.IsZero() || pipeline.Builds[1].Runner.Tags[0] != "docker" {
+				t.Errorf("pipeline builds decoded wrong: %+v", pipeline.Builds)
+			}
+			expectTime(t, pipeline.ObjectAttributes.FinishedAt, "2016-08-12T15:26:29Z")
+		}},
+		{"job.json", "Job Hook", func(t *testing.T, event interface{}) {
+			job := event.(*JobEvent)
+			if job.BuildStatus != "failed" || job.RetriesCount != 2 || job.PipelineID != 2366 || *job.BuildDuration != 285.5 {
+				t.Errorf("job decoded wrong: %+v", job)
+			}
+			if job.Commit.AuthorEmail != "user@gitlab.com" || job.Project.PathWithNamespace != "gitlab-org/gitlab-test" {
+				t.Errorf("job commit decoded wrong: %+v", job.Commit)
+			}
+			expectTime(t, job.BuildFinishedAt, "2021-02-23T02:46:25.512Z")
+		}},
+		{"deployment.json", "Deployment Hook", func(t *testing.T, event interface{}) {
+			deployment := event.(*DeploymentEvent)
+			if deployment.Status != "success" || deployment.Environment != "staging" || deployment.DeploymentID != 15 {
+				t.Errorf("deployment decoded wrong: %+v", deployment)
+			}
+			expectTime(t, deployment.StatusChangedAt, "2021-04-28T19:50:00Z")
+		}},
+		{"wiki_page.json", "Wiki Page Hook", func(t *testing.T, event interface{}) {
+			wiki := event.(*WikiPageEvent)
+			if wiki.ObjectAttributes.Action != "update" || wiki.ObjectAttributes.Slug != "awesome" || wiki.Wiki.PathWithNamespace != "root/awesome-project.wiki" {
+				t.Errorf("wiki page decoded wrong: %+v", wiki.ObjectAttributes)
+			}
+			if wiki.ObjectAttributes.DiffURL == "" || wiki.User.Username != "root" {
+				t.Errorf("wiki page decoded wrong: %+v", wiki)
+			}
+		}},
+		{"release.json", "Release Hook", func(t *testing.T, event interface{}) {
+			release := event.(*ReleaseEvent)
+			if release.Tag != "v1.1" || release.Action != "create" || len(release.Assets.Sources) != 2 {
+				t.Errorf("release decoded wrong: %+v", release)
+			}
+			expectTime(t, release.ReleasedAt, "2020-11-02T12:55:12Z")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			body, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, event, err := Parse(test.header, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, event)
+		})
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	tests := []struct {
+		header string
+		body   string
+		kind   string
+		err    error
+	}{
+		{"Note Hook", `{"object_kind": "note"}`, KindNote, nil},
+		{"", `{"object_kind": "note"}`, KindNote, nil},
+		{"Job Hook", `{}`, KindJob, nil},
+		{"Wiki Page Hook", `{"object_kind": "wiki_page"}`, KindWikiPage, nil},
+		{"", `{"object_kind": "feature_flag"}`, "feature_flag", ErrUnsupportedKind},
+		{"Pipeline Hook", `{"object_kind": "note"}`, "", &KindError{Header: "Pipeline Hook", ObjectKind: "note"}},
+		{"Something Hook", `{}`, "", &KindError{Header: "Something Hook"}},
+	}
+
+	for _, test := range tests {
+		kind, _, err := Parse(test.header, []byte(test.body))
+		if kind != test.kind || !reflect.DeepEqual(err, test.err) {
+			t.Errorf("Parse(%q, %s) = %q, %v want %q, %v", test.header, test.body, kind, err, test.kind, test.err)
+		}
+	}
+}
+
+func TestParseMalformedPayload(t *testing.T) {
+	if _, _, err := Parse("Note Hook", []byte(`not json`)); err == nil {
+		t.Errorf("malformed payload should fail to parse")
+	}
+	if _, _, err := Parse("Note Hook", []byte(`{"object_attributes": {"id": "1244"}}`)); err == nil {
+		t.Errorf("payload with the wrong types should fail to parse")
+	}
+}
+
+func TestTimeFormats(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`"2015-05-17 18:21:36 UTC"`, "2015-05-17T18:21:36Z"},
+		{`"2021-04-28 21:50:00 +0200"`, "2021-04-28T19:50:00Z"},
+		{`"2013-12-03T17:23:34Z"`, "2013-12-03T17:23:34Z"},
+		{`"2021-02-23T02:41:37.886Z"`, "2021-02-23T02:41:37.886Z"},
+		{`"2014-02-27T10:06:20+02:00"`, "2014-02-27T08:06:20Z"},
+		{`null`, ""},
+		{`""`, ""},
+		{`"yesterday"`, ""},
+		{`12`, ""},
+	}
+
+	for _, test := range tests {
+		var value Time
+		if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+			t.Errorf("%s failed to decode: %v", test.value, err)
+			continue
+		}
+		expectTime(t, value, test.want)
+	}
+}
+
+func expectTime(t *testing.T, got Time, want string) {
+	t.Helper()
+	if want == "" {
+		if !got.IsZero() {
+			t.Errorf("time should be zero: got %v", got)
+		}
+		return
+	}
+
+	expected, err := time.Parse(time.RFC3339Nano, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(expected) {
+		t.Errorf("time decoded wrong: got %v want %v", got, expected)
+	}
+}
+
+func TestDraftTitle(t *testing.T) {
+	tests := map[string]bool{
+		"Draft: Fix the build":  true,
+		"[Draft] Fix the build": true,
+		"WIP: Fix the build":    true,
+		"draft:fix":             true,
+		"Fix the draft build":   false,
+		"Fix the build":         false,
+	}
+
+	for title, expected := range tests {
+		if draft := DraftTitle(title); draft != expected {
+			t.Errorf("DraftTitle(%q) = %v want %v", title, draft, expected)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	samples := map[string]string{
+		"push.json":               "Push Hook",
+		"tag_push.json":           "Tag Push Hook",
+		"note_merge_request.json": "Note Hook",
+		"note_commit.json":        "Note Hook",
+		"note_issue.json":         "Confidential Note Hook",
+		"note_snippet.json":       "Note Hook",
+		"issue.json":              "Issue Hook",
+		"merge_request.json":      "Merge Request Hook",
+		"wiki_page.json":          "Wiki Page Hook",
+		"pipeline.json":           "Pipeline Hook",
+		"job.json":                "Job Hook",
+		"deployment.json":         "Deployment Hook",
+		"release.json":            "Release Hook",
+	}
+	for file, header := range samples {
+		body, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(header, body)
+	}
+	f.Add("", []byte(`{"object_kind": "feature_flag"}`))
+	f.Add("Note Hook", []byte(`{"object_kind": "note", "object_attributes": {"created_at": "2015-05-17 18:21:36 UTC"}}`))
+	f.Add("Push Hook", []byte(`null`))
+
+	f.Fuzz(func(t *testing.T, header string, body []byte) {
+		kind, event, err := Parse(header, body)
+
+		switch err.(type) {
+		case nil:
+			if event == nil || kind == "" {
+				t.Errorf("Parse returned no error without an event: kind %q event %v", kind, event)
+			}
+		case *KindError:
+			if event != nil || kind != "" {
+				t.Errorf("Parse returned an event with a kind error: kind %q event %v", kind, event)
+			}
+		default:
+			if event != nil {
+				t.Errorf("Parse returned an event with an error: %v", err)
+			}
+			if err == ErrUnsupportedKind && kind == "" {
+				t.Error("Parse returned an unsupported kind without the kind")
+			}
+		}
+
+		// Only valid JSON is ever decoded into an event
+		if err == nil && !json.Valid(body) {
+			t.Errorf("Parse decoded invalid JSON %q", body)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package webhook
+
+// WikiPageEvent is sent when a wiki page is created, updated or deleted.
+type WikiPageEvent struct {
+	ObjectKind       string    `json:"object_kind"`
+	User             *User     `json:"user"`
+	Project          *Project  `json:"project"`
+	Wiki             *Wiki     `json:"wiki"`
+	ObjectAttributes *WikiPage `json:"object_attributes"`
+}
+
+type Wiki struct {
+	WebURL            string `json:"web_url"`
+	GitSSHURL         string `json:"git_ssh_url"`
+	GitHTTPURL        string `json:"git_http_url"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+type WikiPage struct {
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	Format    string `json:"format"`
+	Message   string `json:"message"`
+	Slug      string `json:"slug"`
+	URL       string `json:"url"`
+	Action    string `json:"action"`
+	DiffURL   string `json:"diff_url"`
+	VersionID string `json:"version_id"`
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandlerDispatchesOnGitlabEvent(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	activeUsers = users
+	handler := http.HandlerFunc(WebhookHandler)
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewBuffer(MergeRequestCommentRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Gitlab-Event", "Note Hook")
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+	time.Sleep(1 * time.Second) // Sleep to let goroutines finish, this is a code smell :(
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	if !strings.Contains(slackStub.receivedMessage, "smeriwether1 made a comment") {
+		t.Errorf("slack client received wrong message: got %v wanted to include %v",
+			slackStub.receivedMessage, "smeriwether1 made a comment")
+	}
+}
+
+func TestWebhookHandlerRejectsMismatchedGitlabEvent(t *testing.T) {
+	handler := http.HandlerFunc(WebhookHandler)
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewBuffer(MergeRequestCommentRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Gitlab-Event", "Pipeline Hook")
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestWebhookHandlerIgnoresUnhandledEvents(t *testing.T) {
+	handler := http.HandlerFunc(WebhookHandler)
+	req, err := http.NewRequest("POST", "/webhook", strings.NewReader(`{"object_kind": "release"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Gitlab-Event", "Release Hook")
+	rr := httptest.NewRecorder()
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusAccepted)
+	}
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func TestCommentWebhookHandlerRejectsOtherEvents(t *testing.T) {
+	handler := http.HandlerFunc(CommentWebhookHandler)
+	req, err := http.NewRequest("POST", "/comments", bytes.NewBuffer(FailedPipelineRequest()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestWebhookHandlerRejectsMalformedPayloads(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+	}{
+		{"malformed", "Note Hook", `{"object_kind": "note",`},
+		{"empty", "Note Hook", ``},
+		{"wrong types", "Note Hook", `{"object_kind": "note", "object_attributes": "nope"}`},
+		{"not an object", "", `["note"]`},
+		{"unknown", "", `{"id": 1}`},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/webhook", strings.NewReader(test.body))
+		req.Header.Set("X-Gitlab-Event", test.header)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(WebhookHandler).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, status, http.StatusBadRequest)
+		}
+		var response struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.Error == "" {
+			t.Errorf("%s: handler returned wrong body: got %q", test.name, rr.Body.String())
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: handler returned wrong content type: got %q", test.name, contentType)
+		}
+	}
+}
+
+func TestWebhookHandlerExplainsIgnoredEvents(t *testing.T) {
+	req := httptest.NewRequest("POST", "/pipeline", bytes.NewBuffer(RunningPipelineRequest()))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(WebhookHandler).ServeHTTP(rr, req)
+
+	expected := `{"ignored":"Pipeline didn't fail"}`
+	if rr.Code != http.StatusAccepted || strings.TrimSpace(rr.Body.String()) != expected {
+		t.Errorf("handler returned wrong response: got %d %s want %d %s",
+			rr.Code, rr.Body.String(), http.StatusAccepted, expected)
+	}
+}
+
+func TestLimitHandlerRejectsLargeBodies(t *testing.T) {
+	handler := LimitHandler{http.HandlerFunc(WebhookHandler), 64}
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(MergeRequestCommentRequest()))
+	req.Header.Set("X-Gitlab-Event", "Note Hook")
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+}
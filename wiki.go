@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/smeriwether/gitlab-slack-notifier/webhook"
+)
+
+var wikiActions = map[string]string{
+	"create": "created",
+	"update": "updated",
+	"delete": "deleted",
+}
+
+func validateWikiPage(event *webhook.WikiPageEvent) error {
+	if event.ObjectAttributes == nil || event.Project == nil {
+		return errors.New("Not valid or not a wiki page request")
+	}
+	return nil
+}
+
+func handleWikiPage(w http.ResponseWriter, event *webhook.WikiPageEvent) {
+	if err := validateWikiPage(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deliver(event.Project.PathWithNamespace, func() { announceWikiPage(event) })
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// announceWikiPage posts a summary of the wiki change to the project's wiki channel.
+func announceWikiPage(event *webhook.WikiPageEvent) {
+	channel := config.Project(event.Project.PathWithNamespace).WikiChannelOrDefault()
+	if channel == "" {
+		return
+	}
+
+	page := event.ObjectAttributes
+	action, ok := wikiActions[page.Action]
+	if !ok {
+		return
+	}
+
+	author := "Someone"
+	if event.User != nil {
+		author = event.User.Username
+	}
+
+	title := fmt.Sprintf("*%s*", page.Title)
+	if page.Action != "delete" {
+		title = fmt.Sprintf("<%s|%s>", page.URL, page.Title)
+	}
+
+	message := fmt.Sprintf(
+		":memo: %s %s wiki page %s in <%s|%s>",
+		author, action, title, event.Project.WebURL, event.Project.PathWithNamespace,
+	)
+	if page.Action == "update" && page.DiffURL != "" {
+		message += fmt.Sprintf(" (<%s|diff>)", page.DiffURL)
+	}
+
+	log.Printf("Announcing wiki page %s of %s\n", page.Slug, event.Project.PathWithNamespace)
+	posted, _ := slackClient.PostMessage(channel, message, page.Message)
+	recordEventDecision(webhook.KindWikiPage, event, channel, sentOrFailed(posted), page.Action)
+}
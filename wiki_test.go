@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestWebhookHandlerWithAnUpdatedWikiPage(t *testing.T) {
+	config = &Config{Projects: map[string]ProjectConfig{
+		"root/awesome-project": {Channel: "#awesome", WikiChannel: "#docs"},
+	}}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	rr := serveEvent(t, "Wiki Page Hook", WikiPageRequest("update"))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	if slackStub.receivedChannel != "#docs" {
+		t.Errorf("slack client received wrong channel: got %v want %v", slackStub.receivedChannel, "#docs")
+	}
+
+	expected := ":memo: root updated wiki page <http://example.com/root/awesome-project/-/wikis/awesome|Awesome> " +
+		"in <http://example.com/root/awesome-project|root/awesome-project> " +
+		"(<http://example.com/root/awesome-project/-/wikis/awesome/diff?version_id=aaaa|diff>)"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+
+	if slackStub.receivedAttachment != "adding an awesome page to the wiki" {
+		t.Errorf("slack client received wrong attachment: got %v want %v",
+			slackStub.receivedAttachment, "adding an awesome page to the wiki")
+	}
+}
+
+func TestWebhookHandlerWithADeletedWikiPage(t *testing.T) {
+	config = &Config{Projects: map[string]ProjectConfig{"root/awesome-project": {Channel: "#awesome"}}}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Wiki Page Hook", WikiPageRequest("delete"))
+
+	if slackStub.receivedChannel != "#awesome" {
+		t.Errorf("slack client received wrong channel: got %v want %v", slackStub.receivedChannel, "#awesome")
+	}
+
+	expected := ":memo: root deleted wiki page *Awesome* in <http://example.com/root/awesome-project|root/awesome-project>"
+	if slackStub.receivedMessage != expected {
+		t.Errorf("slack client received wrong message: got %v want %v", slackStub.receivedMessage, expected)
+	}
+}
+
+func TestWebhookHandlerWithAWikiPageOfAnUnconfiguredProject(t *testing.T) {
+	config = &Config{}
+	slackStub := slackClientStub{}
+	slackClient = &slackStub
+
+	serveEvent(t, "Wiki Page Hook", WikiPageRequest("create"))
+
+	if slackStub.receivedMessage != "" {
+		t.Errorf("slack client received wrong message: got %v want (empty)", slackStub.receivedMessage)
+	}
+}
+
+func WikiPageRequest(action string) []byte {
+	return []byte(fmt.Sprintf(
+		`
+	{
+		"object_kind": "wiki_page",
+		"user": {
+			"id": 1,
+			"name": "Administrator",
+			"username": "root",
+			"email": "admin@example.com"
+		},
+		"project": {
+			"id": 1,
+			"name": "awesome-project",
+			"web_url": "http://example.com/root/awesome-project",
+			"path_with_namespace": "root/awesome-project",
+			"default_branch": "master"
+		},
+		"wiki": {
+			"web_url": "http://example.com/root/awesome-project/-/wikis/home",
+			"path_with_namespace": "root/awesome-project.wiki",
+			"default_branch": "master"
+		},
+		"object_attributes": {
+			"title": "Awesome",
+			"content": "awesome content goes here",
+			"format": "markdown",
+			"message": "adding an awesome page to the wiki",
+			"slug": "awesome",
+			"url": "http://example.com/root/awesome-project/-/wikis/awesome",
+			"action": %q,
+			"diff_url": "http://example.com/root/awesome-project/-/wikis/awesome/diff?version_id=aaaa",
+			"version_id": "aaaa"
+		}
+	}
+	`,
+		action,
+	))
+}
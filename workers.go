@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers = 8
+	// workerQueueSize is how many jobs wait for each worker before whoever submits the next one has to wait too.
+	workerQueueSize = 100
+	// eventQueueSize is larger since every pipeline of a project that isn't for a merge request lands on the
+	// same worker, a runner outage failing them all at once shouldn't overflow it.
+	eventQueueSize = 5000
+)
+
+// eventQueueWait is how long a webhook waits for room on a full worker before the event is dropped. Gitlab
+// gives up on a delivery after 10 seconds and never sends it again.
+var eventQueueWait = 5 * time.Second
+
+// eventWorkers handle webhooks after they've been acknowledged and sendWorkers post to Slack. Both are nil
+// when webhooks are handled as they arrive, like outside of serve or with a negative number of workers.
+var (
+	eventWorkers *WorkerPool
+	sendWorkers  *WorkerPool
+)
+
+// WorkerPool runs jobs on a fixed number of goroutines. Jobs with the same key always go to the same
+// worker, so they run one at a time in the order they were submitted.
+type WorkerPool struct {
+	mu     sync.RWMutex
+	closed bool
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	pool := &WorkerPool{}
+	for i := 0; i < workers; i++ {
+		queue := make(chan func(), queueSize)
+		pool.queues = append(pool.queues, queue)
+		pool.wg.Add(1)
+		go pool.work(queue)
+	}
+	return pool
+}
+
+func (p *WorkerPool) work(queue chan func()) {
+	defer p.wg.Done()
+	for job := range queue {
+		runJob(job)
+	}
+}
+
+// runJob keeps a panic in one job from taking the worker, and everything queued behind it, down with it.
+func runJob(job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Recovered from a panic in a worker:", r)
+		}
+	}()
+	job()
+}
+
+// Submit queues the job on the worker for the key, waiting while that worker's queue is full.
+// Once the pool is closed jobs are run right away instead, late comment batches still get sent.
+func (p *WorkerPool) Submit(key string, job func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		runJob(job)
+		return
+	}
+
+	p.queue(key) <- job
+}
+
+// SubmitWithin queues the job like Submit but gives up when the worker's queue is still full after the wait.
+func (p *WorkerPool) SubmitWithin(key string, job func(), wait time.Duration) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		runJob(job)
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case p.queue(key) <- job:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (p *WorkerPool) queue(key string) chan func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return p.queues[hash.Sum32()%uint32(len(p.queues))]
+}
+
+// Close waits for every queued job to finish.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// startWorkers sets up the worker pools, the returned function drains them and sends any pending comment batches.
+func startWorkers(workers int) func() {
+	eventWorkers = NewWorkerPool(workers, eventQueueSize)
+	sendWorkers = NewWorkerPool(workers, workerQueueSize)
+	return func() {
+		// Events queue messages and add comments to batches, so they have to be done before the batches are
+		// flushed, and the batches before the senders are
+		eventWorkers.Close()
+		pendingComments.FlushAll()
+		sendWorkers.Close()
+	}
+}
+
+// deliver sends to a recipient on the sender for its key so every recipient gets their messages in order.
+// The key is the Slack ID of the recipient, or the project for announcements that work out where they go as
+// they're sent. Without workers it's a goroutine like it always was.
+func deliver(key string, send func()) {
+	if sendWorkers == nil {
+		go send()
+		return
+	}
+	sendWorkers.Submit(key, send)
+}
+
+// deliverEach delivers to each of the receivers on their own.
+func deliverEach(receivers []*User, send func(receiver *User)) {
+	for _, receiver := range receivers {
+		receiver := receiver
+		deliver(receiver.SlackID, func() { send(receiver) })
+	}
+}
+
+// eventKey keeps events about the same merge request, or the same project when there isn't one, on the same
+// worker so they're handled in the order Gitlab sent them.
+func eventKey(kind string, event interface{}) string {
+	project, mergeRequest, _ := eventSubject(event)
+	if mergeRequest != 0 {
+		return fmt.Sprintf("%s!%d", project, mergeRequest)
+	}
+	return project + " " + kind
+}
+
+// queuedResponse stands in for the response to an event that was handled after it was acknowledged,
+// there's nobody to tell about errors anymore so they're logged.
+type queuedResponse struct {
+	kind   string
+	header http.Header
+	status int
+}
+
+func newQueuedResponse(kind string) *queuedResponse {
+	return &queuedResponse{kind: kind, header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *queuedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *queuedResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *queuedResponse) Write(body []byte) (int, error) {
+	if r.status >= 400 {
+		log.Printf("Error handling %s event: %d %s\n", r.kind, r.status, strings.TrimSpace(string(body)))
+	}
+	return len(body), nil
+}
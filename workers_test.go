@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolRunsJobsWithTheSameKeyInOrder(t *testing.T) {
+	pool := NewWorkerPool(4, 2)
+
+	var mu sync.Mutex
+	var order []int
+	for i := 0; i < 50; i++ {
+		i := i
+		pool.Submit("SLACKID1", func() {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		})
+	}
+	pool.Close()
+
+	if len(order) != 50 {
+		t.Fatalf("ran wrong number of jobs: got %d want 50", len(order))
+	}
+	for i, job := range order {
+		if job != i {
+			t.Fatalf("ran jobs out of order: %v", order)
+		}
+	}
+}
+
+func TestWorkerPoolIsBounded(t *testing.T) {
+	pool := NewWorkerPool(2, 1)
+
+	var running, most int32
+	for i := 0; i < 20; i++ {
+		pool.Submit(fmt.Sprintf("SLACKID%d", i), func() {
+			now := atomic.AddInt32(&running, 1)
+			for {
+				previous := atomic.LoadInt32(&most)
+				if now <= previous || atomic.CompareAndSwapInt32(&most, previous, now) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	pool.Close()
+
+	if most > 2 {
+		t.Errorf("ran too many jobs at once: got %d want at most 2", most)
+	}
+}
+
+func TestWorkerPoolSurvivesPanicsAndRunsLateJobs(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+
+	ran := false
+	pool.Submit("SLACKID1", func() { panic("oops") })
+	pool.Submit("SLACKID1", func() { ran = true })
+	pool.Close()
+	if !ran {
+		t.Error("a panic stopped the worker from running the next job")
+	}
+
+	ran = false
+	pool.Submit("SLACKID1", func() { ran = true })
+	if !ran {
+		t.Error("a job submitted after closing wasn't run")
+	}
+}
+
+// gatedSlackClient doesn't send anything until it's released.
+type gatedSlackClient struct {
+	*slackClientStub
+	release chan struct{}
+}
+
+func (gate gatedSlackClient) PostInteractiveMessage(channel, message, attachment string, actions []MessageAction) (string, string) {
+	<-gate.release
+	return gate.slackClientStub.PostInteractiveMessage(channel, message, attachment, actions)
+}
+
+func TestWebhookHandlerAcknowledgesBeforeHandling(t *testing.T) {
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	defer func(previous SlackReadWriter) { slackClient = previous }(slackClient)
+	activeUsers = users
+	slackStub := &slackClientStub{}
+	gate := gatedSlackClient{slackStub, make(chan struct{})}
+	slackClient = gate
+
+	stopWorkers := startWorkers(2)
+	defer func() { eventWorkers, sendWorkers = nil, nil }()
+
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(MergeRequestCommentRequest()))
+	req.Header.Set("X-Gitlab-Event", "Note Hook")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(WebhookHandler).ServeHTTP(rr, req)
+
+	if expected := `{"queued":"note"}`; rr.Code != http.StatusAccepted || strings.TrimSpace(rr.Body.String()) != expected {
+		t.Errorf("handler returned wrong response: got %d %s want %d %s",
+			rr.Code, rr.Body.String(), http.StatusAccepted, expected)
+	}
+
+	// Invalid events are still turned away while Gitlab waits
+	req = httptest.NewRequest("POST", "/webhook", strings.NewReader(`{"object_kind": "note"}`))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(WebhookHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	close(gate.release)
+	stopWorkers()
+
+	if !strings.Contains(slackStub.receivedMessage, "smeriwether1 made a comment") || slackStub.receivedChannel != "SLACKID2" {
+		t.Errorf("slack client received wrong message: got %v to %v", slackStub.receivedMessage, slackStub.receivedChannel)
+	}
+}
+
+func TestStoppingWorkersSendsCommentsQueuedBehindBusyWorkers(t *testing.T) {
+	defer func(previous *Config) { config = previous }(config)
+	defer func(previous *[]User) { activeUsers = previous }(activeUsers)
+	config = &Config{CommentBatchSeconds: 60}
+	activeUsers = users
+	slackStub := &slackClientStub{}
+	slackClient = slackStub
+
+	stopWorkers := startWorkers(1)
+	defer func() { eventWorkers, sendWorkers = nil, nil }()
+	release := make(chan struct{})
+	eventWorkers.Submit("busy", func() { <-release })
+
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(MergeRequestCommentRequest()))
+	req.Header.Set("X-Gitlab-Event", "Note Hook")
+	http.HandlerFunc(WebhookHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	// The note is only handled, and batched, once the bot is already stopping
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	stopWorkers()
+
+	if !reflect.DeepEqual(slackStub.receivedChannels, []string{"SLACKID2"}) {
+		t.Errorf("slack client received wrong channels: got %v want %v", slackStub.receivedChannels, []string{"SLACKID2"})
+	}
+}
+
+func TestWebhookHandlerDropsEventsWhenTheWorkersAreBusy(t *testing.T) {
+	defer func(previous *HistoryStore) { history = previous }(history)
+	defer func(previous time.Duration) { eventQueueWait = previous }(eventQueueWait)
+	defer func() { eventWorkers = nil }()
+	history = NewHistoryStore(time.Hour)
+	eventQueueWait = 10 * time.Millisecond
+	// A pool nobody works on fills up after a single event
+	eventWorkers = &WorkerPool{queues: []chan func(){make(chan func(), 1)}}
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(MergeRequestCommentRequest()))
+		req.Header.Set("X-Gitlab-Event", "Note Hook")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(WebhookHandler).ServeHTTP(rr, req)
+
+		// Gitlab disables hooks that keep failing, a dropped event is no error of theirs
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+		}
+		bodies = append(bodies, strings.TrimSpace(rr.Body.String()))
+	}
+
+	expected := []string{`{"queued":"note"}`, `{"ignored":"Too busy, the event was dropped"}`}
+	if !reflect.DeepEqual(bodies, expected) {
+		t.Errorf("handler returned wrong bodies: got %v want %v", bodies, expected)
+	}
+
+	records := history.Query(HistoryQuery{Project: "gitlab-org/gitlab-test"})
+	if len(records) == 0 || records[0].Decision != DecisionDropped {
+		t.Errorf("dropped event wasn't recorded: %+v", records)
+	}
+}
+
+func TestWorkerPoolSubmitWithinWaitsForRoom(t *testing.T) {
+	pool := &WorkerPool{queues: []chan func(){make(chan func(), 1)}}
+	pool.queues[0] <- func() {}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-pool.queues[0]
+	}()
+	if !pool.SubmitWithin("SLACKID1", func() {}, time.Second) {
+		t.Error("job wasn't queued once there was room")
+	}
+	if pool.SubmitWithin("SLACKID1", func() {}, 10*time.Millisecond) {
+		t.Error("job was queued on a full worker")
+	}
+}